@@ -17,9 +17,11 @@ func main() {
 	app := tview.NewApplication()
 
 	app.SetBeforeDrawFunc(func(screen tcell.Screen) bool {
-		return modules.ExpressionsUpdate() &&
-			modules.GraphUpdate() &&
-			modules.InformationUpdate()
+		// Every update runs on each frame, the frame is skipped if any of them asks for it
+		expressions := modules.ExpressionsUpdate()
+		graph := modules.GraphUpdate()
+		information := modules.InformationUpdate()
+		return expressions || graph || information
 	})
 
 	graph := tview.NewFlex().SetDirection(tview.FlexColumnCSS).
@@ -0,0 +1,380 @@
+package modules
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Node is one element of a parsed expression tree, every node remembers
+// where in the source text it came from so errors can point back at it
+type Node interface {
+	Pos() int
+	String() string
+}
+
+// NumberNode is a numeric literal, Literal keeps its source text so it can be read exactly
+// (0.1 has no exact float64), it is empty for numbers made up while simplifying
+type NumberNode struct {
+	Value    float64
+	Literal  string
+	Position int
+}
+
+// VariableNode is a free variable such as x
+type VariableNode struct {
+	Name     string
+	Position int
+}
+
+// ConstantNode is a named constant, built in (pi, e, ...) or user defined
+type ConstantNode struct {
+	Name     string
+	Position int
+}
+
+// UnitNode is a unit of measurement such as m or kN, worth its size in SI base units
+type UnitNode struct {
+	Name     string
+	Position int
+}
+
+// UnaryNode is a prefix operator applied to a single operand, currently only negation
+type UnaryNode struct {
+	Op       string
+	Operand  Node
+	Position int
+}
+
+// BinaryNode is an infix operator (+, -, *, /, ^) applied to two operands
+type BinaryNode struct {
+	Op       string
+	Left     Node
+	Right    Node
+	Position int
+}
+
+// CallNode is a function call, built in or user defined, a name ending in ' calls the derivative of a user function
+type CallNode struct {
+	Name     string
+	Args     []Node
+	Position int
+}
+
+// DerivativeNode is d/dx(Operand), Result holds its symbolic derivative,
+// or nil when there is none and it has to be approximated numerically
+type DerivativeNode struct {
+	Operand  Node
+	Result   Node
+	Position int
+}
+
+// IntegralNode is the definite integral of Body over Variable from Lower to Upper, written
+// int(t^2, t, 0, 1) or integral(f, 0, 1), Variable only has a value inside Body
+type IntegralNode struct {
+	Body     Node
+	Variable string
+	Lower    Node
+	Upper    Node
+	Position int
+}
+
+// SeriesNode is the sum (Op "sum") or product (Op "prod") of Body for every whole number Index
+// from From to To, written sum(k, 1, n, 1/k^2), Index only has a value inside Body
+type SeriesNode struct {
+	Op       string
+	Index    string
+	From     Node
+	To       Node
+	Body     Node
+	Position int
+}
+
+// StatNode is a summary of a column of a table row, written mean(t1.y), Column is "x" or "y"
+type StatNode struct {
+	Func     string
+	Table    string
+	Column   string
+	Position int
+}
+
+// TupleNode is a parenthesized list of coordinates such as (cos(t), sin(t)), it is not a number
+// itself and only makes sense as the whole of a row
+type TupleNode struct {
+	Items    []Node
+	Position int
+}
+
+// EquationNode is Left = Right, like a tuple it is only meaningful as the whole of a row,
+// where it is drawn as the curve on which both sides agree
+type EquationNode struct {
+	Left     Node
+	Right    Node
+	Position int
+}
+
+// ComparisonNode is Left < Right, <=, >, >= or !=, it is a condition rather than a number
+type ComparisonNode struct {
+	Op       string
+	Left     Node
+	Right    Node
+	Position int
+}
+
+// LogicalNode joins two conditions with "and" or "or"
+type LogicalNode struct {
+	Op       string
+	Left     Node
+	Right    Node
+	Position int
+}
+
+// PiecewiseNode is the value of the first piece whose condition holds, or Otherwise (nil when
+// there is none) if no condition does, written {x < 0: -x, x^2} or piecewise(x < 0, -x, x^2)
+type PiecewiseNode struct {
+	Conditions []Node
+	Values     []Node
+	Otherwise  Node
+	Position   int
+}
+
+func (n *NumberNode) Pos() int   { return n.Position }
+func (n *VariableNode) Pos() int { return n.Position }
+func (n *ConstantNode) Pos() int { return n.Position }
+func (n *UnitNode) Pos() int     { return n.Position }
+func (n *UnaryNode) Pos() int    { return n.Position }
+func (n *BinaryNode) Pos() int   { return n.Position }
+func (n *CallNode) Pos() int     { return n.Position }
+
+func (n *DerivativeNode) Pos() int { return n.Position }
+func (n *IntegralNode) Pos() int   { return n.Position }
+func (n *SeriesNode) Pos() int     { return n.Position }
+func (n *StatNode) Pos() int       { return n.Position }
+func (n *TupleNode) Pos() int      { return n.Position }
+func (n *EquationNode) Pos() int   { return n.Position }
+func (n *ComparisonNode) Pos() int { return n.Position }
+func (n *LogicalNode) Pos() int    { return n.Position }
+func (n *PiecewiseNode) Pos() int  { return n.Position }
+
+func (n *NumberNode) String() string   { return strconv.FormatFloat(n.Value, 'g', -1, 64) }
+func (n *VariableNode) String() string { return n.Name }
+func (n *ConstantNode) String() string { return n.Name }
+func (n *UnitNode) String() string     { return n.Name }
+
+func (n *UnaryNode) String() string {
+	return "-" + wrapOperand(n.Operand, precedence["u-"], false)
+}
+
+func (n *BinaryNode) String() string {
+	prec := precedence[n.Op]
+	left := wrapOperand(n.Left, prec, n.Op == "^")
+	right := wrapOperand(n.Right, prec, n.Op != "^" && n.Op != "+" && n.Op != "*")
+	if n.Op == "^" {
+		return left + "^" + right
+	}
+	return left + " " + n.Op + " " + right
+}
+
+func (n *CallNode) String() string {
+	if n.Name == "factorial" && len(n.Args) == 1 {
+		return wrapOperand(n.Args[0], atomPrecedence, false) + "!"
+	}
+	args := make([]string, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = arg.String()
+	}
+	return n.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (n *DerivativeNode) String() string { return "d/dx(" + n.Operand.String() + ")" }
+
+func (n *IntegralNode) String() string {
+	return "int(" + n.Body.String() + ", " + n.Variable + ", " + n.Lower.String() + ", " + n.Upper.String() + ")"
+}
+
+func (n *SeriesNode) String() string {
+	return n.Op + "(" + n.Index + ", " + n.From.String() + ", " + n.To.String() + ", " + n.Body.String() + ")"
+}
+
+func (n *StatNode) String() string { return n.Func + "(" + n.Table + "." + n.Column + ")" }
+
+func (n *TupleNode) String() string {
+	items := make([]string, len(n.Items))
+	for i, item := range n.Items {
+		items[i] = item.String()
+	}
+	return "(" + strings.Join(items, ", ") + ")"
+}
+
+func (n *EquationNode) String() string { return n.Left.String() + " = " + n.Right.String() }
+
+func (n *ComparisonNode) String() string {
+	return n.Left.String() + " " + n.Op + " " + n.Right.String()
+}
+
+// "and" binds tighter than "or", so only an "or" inside an "and" needs parentheses
+func (n *LogicalNode) String() string {
+	wrap := func(node Node) string {
+		if inner, ok := node.(*LogicalNode); ok && inner.Op == "or" && n.Op == "and" {
+			return "(" + inner.String() + ")"
+		}
+		return node.String()
+	}
+	return wrap(n.Left) + " " + n.Op + " " + wrap(n.Right)
+}
+
+func (n *PiecewiseNode) String() string {
+	var pieces []string
+	for i, condition := range n.Conditions {
+		pieces = append(pieces, condition.String()+": "+n.Values[i].String())
+	}
+	if n.Otherwise != nil {
+		pieces = append(pieces, n.Otherwise.String())
+	}
+	return "{" + strings.Join(pieces, ", ") + "}"
+}
+
+// Reports whether node is a comparison or a combination of them, rather than a number
+func isCondition(node Node) bool {
+	switch node.(type) {
+	case *ComparisonNode, *LogicalNode:
+		return true
+	}
+	return false
+}
+
+// Parenthesizes a child node when its operator binds looser than its parent's,
+// strict also wraps children of equal precedence (right side of - and /, left side of ^)
+func wrapOperand(node Node, parentPrec int, strict bool) string {
+	prec := nodePrecedence(node)
+	if prec < parentPrec || (strict && prec == parentPrec) {
+		return "(" + node.String() + ")"
+	}
+	return node.String()
+}
+
+// Atoms and calls bind tighter than any operator, so they are never parenthesized
+const atomPrecedence = 10
+
+func nodePrecedence(node Node) int {
+	switch n := node.(type) {
+	case *BinaryNode:
+		return precedence[n.Op]
+	case *UnaryNode:
+		return precedence["u-"]
+	case *NumberNode:
+		if n.Value < 0 {
+			return precedence["u-"]
+		}
+	}
+	return atomPrecedence
+}
+
+// Reports whether the tree references the variable name anywhere
+func usesVariable(node Node, name string) bool {
+	switch n := node.(type) {
+	case *VariableNode:
+		return n.Name == name
+	case *UnaryNode:
+		return usesVariable(n.Operand, name)
+	case *BinaryNode:
+		return usesVariable(n.Left, name) || usesVariable(n.Right, name)
+	case *CallNode:
+		for _, arg := range n.Args {
+			if usesVariable(arg, name) {
+				return true
+			}
+		}
+	case *DerivativeNode:
+		return usesVariable(n.Operand, name)
+	case *IntegralNode:
+		return (n.Variable != name && usesVariable(n.Body, name)) || usesVariable(n.Lower, name) || usesVariable(n.Upper, name)
+	case *SeriesNode:
+		return (n.Index != name && usesVariable(n.Body, name)) || usesVariable(n.From, name) || usesVariable(n.To, name)
+	case *TupleNode:
+		for _, item := range n.Items {
+			if usesVariable(item, name) {
+				return true
+			}
+		}
+	case *EquationNode:
+		return usesVariable(n.Left, name) || usesVariable(n.Right, name)
+	case *ComparisonNode:
+		return usesVariable(n.Left, name) || usesVariable(n.Right, name)
+	case *LogicalNode:
+		return usesVariable(n.Left, name) || usesVariable(n.Right, name)
+	case *PiecewiseNode:
+		for i, condition := range n.Conditions {
+			if usesVariable(condition, name) || usesVariable(n.Values[i], name) {
+				return true
+			}
+		}
+		return n.Otherwise != nil && usesVariable(n.Otherwise, name)
+	}
+	return false
+}
+
+// Names of the variables, constants, functions and tables the tree refers to, in the order they first appear,
+// calls to f' are listed as f
+func referencedNames(node Node) []string {
+	var names []string
+	add := func(name string) {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	var visit func(Node)
+	visit = func(node Node) {
+		switch n := node.(type) {
+		case *VariableNode:
+			add(n.Name)
+		case *ConstantNode:
+			add(n.Name)
+		case *UnaryNode:
+			visit(n.Operand)
+		case *BinaryNode:
+			visit(n.Left)
+			visit(n.Right)
+		case *CallNode:
+			add(strings.TrimRight(n.Name, "'"))
+			for _, arg := range n.Args {
+				visit(arg)
+			}
+		case *DerivativeNode:
+			visit(n.Operand)
+		case *IntegralNode:
+			visit(n.Body)
+			visit(n.Lower)
+			visit(n.Upper)
+		case *SeriesNode:
+			visit(n.From)
+			visit(n.To)
+			visit(n.Body)
+		case *StatNode:
+			add(n.Table)
+		case *TupleNode:
+			for _, item := range n.Items {
+				visit(item)
+			}
+		case *EquationNode:
+			visit(n.Left)
+			visit(n.Right)
+		case *ComparisonNode:
+			visit(n.Left)
+			visit(n.Right)
+		case *LogicalNode:
+			visit(n.Left)
+			visit(n.Right)
+		case *PiecewiseNode:
+			for i, condition := range n.Conditions {
+				visit(condition)
+				visit(n.Values[i])
+			}
+			if n.Otherwise != nil {
+				visit(n.Otherwise)
+			}
+		}
+	}
+	visit(node)
+	return names
+}
@@ -0,0 +1,155 @@
+package modules
+
+import (
+	"fmt"
+	"math"
+)
+
+// Mathematical constants
+var mathConstants = map[string]float64{
+	"e":   math.E,
+	"pi":  math.Pi,
+	"phi": math.Phi,
+	"tau": 2 * math.Pi,
+}
+
+// A built in function taking between minArgs and maxArgs arguments, maxArgs is -1 for variadic functions.
+// call reports domain errors itself, the evaluator attaches the position of the call.
+type builtinFunction struct {
+	minArgs int
+	maxArgs int
+	call    func(args []float64) (float64, error)
+}
+
+// Built in mathematical functions
+var mathFuncs = map[string]builtinFunction{
+	"sin": unaryBuiltin(math.Sin),
+	"cos": unaryBuiltin(math.Cos),
+	"tan": unaryBuiltin(math.Tan),
+	"sqrt": {1, 1, func(args []float64) (float64, error) {
+		if args[0] < 0 {
+			return 0, fmt.Errorf("domain error: sqrt(%f) - square root of negative number", args[0])
+		}
+		return math.Sqrt(args[0]), nil
+	}},
+	"ln": {1, 1, func(args []float64) (float64, error) {
+		if args[0] <= 0 {
+			return 0, fmt.Errorf("domain error: ln(%f) - logarithm of non-positive number", args[0])
+		}
+		return math.Log(args[0]), nil
+	}},
+	// log(x) is base 10, log(b, x) is base b
+	"log": {1, 2, func(args []float64) (float64, error) {
+		base, a := 10.0, args[0]
+		if len(args) == 2 {
+			base, a = args[0], args[1]
+		}
+		if base <= 0 || base == 1 {
+			return 0, fmt.Errorf("domain error: log base %f - base must be positive and not 1", base)
+		}
+		if a <= 0 {
+			return 0, fmt.Errorf("domain error: log(%f) - logarithm of non-positive number", a)
+		}
+		switch base {
+		case 10:
+			return math.Log10(a), nil
+		case 2:
+			return math.Log2(a), nil
+		}
+		return math.Log(a) / math.Log(base), nil
+	}},
+	"exp": unaryBuiltin(math.Exp),
+	"abs": unaryBuiltin(math.Abs),
+	"asin": {1, 1, func(args []float64) (float64, error) {
+		if args[0] < -1 || args[0] > 1 {
+			return 0, fmt.Errorf("domain error: asin(%f) - argument must be between -1 and 1", args[0])
+		}
+		return math.Asin(args[0]), nil
+	}},
+	"acos": {1, 1, func(args []float64) (float64, error) {
+		if args[0] < -1 || args[0] > 1 {
+			return 0, fmt.Errorf("domain error: acos(%f) - argument must be between -1 and 1", args[0])
+		}
+		return math.Acos(args[0]), nil
+	}},
+	"atan":  unaryBuiltin(math.Atan),
+	"atan2": {2, 2, func(args []float64) (float64, error) { return math.Atan2(args[0], args[1]), nil }},
+	"hypot": {2, 2, func(args []float64) (float64, error) { return math.Hypot(args[0], args[1]), nil }},
+	"floor": unaryBuiltin(math.Floor),
+	"ceil":  unaryBuiltin(math.Ceil),
+	"round": unaryBuiltin(math.Round),
+	// mod takes the sign of the divisor, so mod(-1, 3) = 2
+	"mod": {2, 2, func(args []float64) (float64, error) {
+		if args[1] == 0 {
+			return 0, fmt.Errorf("domain error: mod(%f, 0) - modulo by zero", args[0])
+		}
+		return args[0] - args[1]*math.Floor(args[0]/args[1]), nil
+	}},
+	"min": {1, -1, func(args []float64) (float64, error) {
+		result := args[0]
+		for _, a := range args[1:] {
+			result = math.Min(result, a)
+		}
+		return result, nil
+	}},
+	"max": {1, -1, func(args []float64) (float64, error) {
+		result := args[0]
+		for _, a := range args[1:] {
+			result = math.Max(result, a)
+		}
+		return result, nil
+	}},
+	"clamp": {3, 3, func(args []float64) (float64, error) {
+		if args[1] > args[2] {
+			return 0, fmt.Errorf("domain error: clamp bounds %f > %f - lower bound must not exceed upper bound", args[1], args[2])
+		}
+		return math.Max(args[1], math.Min(args[0], args[2])), nil
+	}},
+	// Parts of complex numbers, see complexFuncs, for real numbers they are trivial
+	"re":   unaryBuiltin(func(x float64) float64 { return x }),
+	"im":   unaryBuiltin(func(x float64) float64 { return 0 }),
+	"conj": unaryBuiltin(func(x float64) float64 { return x }),
+	"arg": unaryBuiltin(func(x float64) float64 {
+		if x < 0 {
+			return math.Pi
+		}
+		return 0
+	}),
+	"d/dx": {1, 1, nil}, // d/dx is handled as a special case, but still needs to be recognized as a "function"
+	// n! is parsed into a call of factorial, the gamma function extends it past whole numbers: 0.5! = sqrt(pi)/2
+	"factorial": {1, 1, func(args []float64) (float64, error) {
+		n := args[0]
+		if n < 0 && n == math.Trunc(n) {
+			return 0, fmt.Errorf("domain error: factorial(%f) - factorial of a negative whole number", n)
+		}
+		if n == math.Trunc(n) && n <= 170 {
+			result := 1.0 // multiplied out, math.Gamma is not exact for whole numbers
+			for k := 2.0; k <= n; k++ {
+				result *= k
+			}
+			return result, nil
+		}
+		return math.Gamma(n + 1), nil
+	}},
+	// sum(k, a, b, expr) and prod(k, a, b, expr) are parsed into a SeriesNode, k only has a value inside expr
+	"sum":  {4, 4, nil},
+	"prod": {4, 4, nil},
+	// int(expr, t, a, b), integral(f, a, b) and integrate(f, a) are parsed into an IntegralNode, t only
+	// has a value inside expr, integrate(f, a) is the integral of f from a to x
+	"int":       {4, 4, nil},
+	"integral":  {3, 3, nil},
+	"integrate": {2, 2, nil},
+	// count, total, mean, median, variance and stdev take a column of a table, mean(t1.y), and are parsed into a StatNode
+	"count":    {1, 1, nil},
+	"total":    {1, 1, nil},
+	"mean":     {1, 1, nil},
+	"median":   {1, 1, nil},
+	"variance": {1, 1, nil},
+	"stdev":    {1, 1, nil},
+	// piecewise(condition, value, ..., otherwise) is parsed into a PiecewiseNode so only the chosen value is evaluated
+	"piecewise": {2, -1, nil},
+}
+
+func unaryBuiltin(f func(float64) float64) builtinFunction {
+	return builtinFunction{1, 1, func(args []float64) (float64, error) { return f(args[0]), nil }}
+}
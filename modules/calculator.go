@@ -0,0 +1,558 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Calculator owns an independent namespace of user defined functions and constants.
+// Everything that parses or evaluates an expression goes through one, so separate
+// instances can be used side by side without seeing each other's definitions.
+type Calculator struct {
+	functions        map[string]userFunction
+	constants        map[string]float64
+	complexConstants map[string]complex128   // constants with an imaginary part, only usable in complex mode
+	constantNodes    map[string]Node         // trees constants were evaluated from, for precise evaluation
+	constantUnits    map[string]dimension    // units of constants that have any, the values are in SI base units
+	derived          map[string]userFunction // cache of f', f'', ... built on demand, reset whenever a function changes
+	tables           map[string]dataTable    // points of table rows, referred to by their columns: t1.x, t1.y
+	complexMode      bool
+	seriesTerms      int64 // most terms a sum or product may have, lowered while the graph is drawn
+}
+
+// A function registered on a Calculator, body is nil for functions registered as Go code,
+// which can then only be differentiated numerically
+type userFunction struct {
+	params []string
+	body   Node
+	fn     func(args []float64) (float64, error)
+}
+
+// Creates an empty Calculator, only the built in functions and constants are known to it
+func NewCalculator() *Calculator {
+	return &Calculator{
+		functions:        make(map[string]userFunction),
+		constants:        make(map[string]float64),
+		complexConstants: make(map[string]complex128),
+		constantNodes:    make(map[string]Node),
+		constantUnits:    make(map[string]dimension),
+		derived:          make(map[string]userFunction),
+		tables:           make(map[string]dataTable),
+		seriesTerms:      maxSeriesTerms,
+	}
+}
+
+// DefaultCalculator backs the package level CreateFunction and Derivative helpers
+var DefaultCalculator = NewCalculator()
+
+// CreateFunction compiles expr using DefaultCalculator
+func CreateFunction(expr string) (func(float64) (float64, error), error) {
+	return DefaultCalculator.CreateFunction(expr)
+}
+
+// Derivative differentiates expr using DefaultCalculator
+func Derivative(expr string) (func(float64) (float64, error), error) {
+	return DefaultCalculator.Derivative(expr)
+}
+
+// Registers (or replaces) a user function under name
+func (c *Calculator) SetFunction(name string, f func(float64) (float64, error)) {
+	c.functions[name] = userFunction{
+		params: []string{"x"},
+		fn:     func(args []float64) (float64, error) { return f(args[0]) },
+	}
+	clear(c.derived)
+}
+
+// Registers (or replaces) a user function from its parameters and parsed body, keeping the tree around for d/dx
+func (c *Calculator) defineFunction(name string, params []string, body Node) {
+	c.functions[name] = c.newUserFunction(params, body)
+	clear(c.derived)
+}
+
+func (c *Calculator) newUserFunction(params []string, body Node) userFunction {
+	return userFunction{
+		params: params,
+		body:   body,
+		fn: func(args []float64) (float64, error) {
+			vars := make(map[string]float64, len(params))
+			for i, param := range params {
+				vars[param] = args[i]
+			}
+			return c.eval(body, vars)
+		},
+	}
+}
+
+// Registers (or replaces) a user constant under name
+func (c *Calculator) SetConstant(name string, value float64) {
+	delete(c.complexConstants, name)
+	delete(c.constantNodes, name)
+	delete(c.constantUnits, name)
+	c.constants[name] = value
+}
+
+// Removes any user function or constant registered under name
+func (c *Calculator) Remove(name string) {
+	delete(c.functions, name)
+	delete(c.constants, name)
+	delete(c.complexConstants, name)
+	delete(c.constantNodes, name)
+	delete(c.constantUnits, name)
+	delete(c.tables, name)
+	clear(c.derived)
+}
+
+// Index of the "=" that separates a definition from its value: the first one outside any brackets
+// that isn't part of <=, >= or !=, -1 when there is none
+func definitionEquals(text string) int {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case '=':
+			if depth == 0 && (i == 0 || !strings.ContainsRune("<>!", rune(text[i-1]))) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Splits "name = expression" or "name(a, b) = expression" at its "=", ok is false for anything
+// else, such as an inequality or an equation like x^2 + y^2 = 9
+func splitDefinition(text string) (lhs, rhs string, ok bool) {
+	eq := definitionEquals(text)
+	if eq == -1 || !namesRow(text[:eq]) {
+		return "", "", false
+	}
+	return text[:eq], text[eq+1:], true
+}
+
+// Define handles user defined constants and functions written as "name = expression",
+// functions can name their parameters: "g(t, k) = k*sin(t)", otherwise x is the parameter
+func (c *Calculator) Define(expr string) error {
+	lhs, rhs, ok := splitDefinition(expr)
+	if !ok {
+		return errors.New("invalid definition format")
+	}
+
+	name, params, err := parseSignature(lhs)
+	if err != nil {
+		return err
+	}
+	// Disallow spaces in identifier and provide a clear error with position
+	if strings.Contains(name, " ") {
+		spacePos := strings.Index(expr, " ")
+		if spacePos == -1 {
+			spacePos = 0
+		}
+		return &ExpressionError{"identifier cannot contain spaces", spacePos}
+	}
+	value := strings.TrimSpace(rhs)
+
+	// Validate the name
+	if len(name) == 0 {
+		return errors.New("empty name in definition")
+	}
+	if !isValidIdentifier(name) {
+		return fmt.Errorf("invalid identifier name: %s", name)
+	}
+	if name == "x" {
+		return errors.New("cannot redefine variable 'x'")
+	}
+	if _, exists := mathFuncs[name]; exists {
+		return fmt.Errorf("cannot redefine built-in function: %s", name)
+	}
+	if _, exists := mathConstants[name]; exists {
+		return fmt.Errorf("cannot redefine built-in constant: %s", name)
+	}
+	if _, exists := c.functions[name]; exists {
+		return fmt.Errorf("function %s is already defined", name)
+	}
+	if _, exists := c.constants[name]; exists {
+		return fmt.Errorf("constant %s is already defined", name)
+	}
+	if _, exists := c.complexConstants[name]; exists {
+		return fmt.Errorf("constant %s is already defined", name)
+	}
+
+	if params != nil {
+		node, err := c.parseWith(value, params)
+		if err == nil {
+			_, err = c.dimensionOf(node, nil)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid function definition: %v", err)
+		}
+		c.defineFunction(name, params, node)
+		return nil
+	}
+
+	// Check if it's a function definition
+	node, err := c.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid expression in definition: %v", err)
+	}
+	unit, err := c.dimensionOf(node, nil)
+	if err != nil {
+		return fmt.Errorf("invalid expression in definition: %v", err)
+	}
+
+	if usesVariable(node, "x") {
+		c.defineFunction(name, []string{"x"}, node)
+	} else if c.complexMode {
+		val, err := c.evalComplex(node, map[string]complex128{"x": 0})
+		if err != nil {
+			return fmt.Errorf("invalid constant definition: %v", err)
+		}
+		c.SetComplexConstant(name, cleanComplex(val))
+		c.setConstantUnits(name, unit)
+	} else {
+		val, err := c.eval(node, map[string]float64{"x": 0})
+		if err != nil {
+			return fmt.Errorf("invalid constant definition: %v", err)
+		}
+		c.constants[name] = val
+		c.constantNodes[name] = node
+		c.setConstantUnits(name, unit)
+	}
+	return nil
+}
+
+// Reports a positioned error when a call to name is given the wrong number of arguments
+func (c *Calculator) checkArity(name string, count int, pos int) error {
+	minArgs, maxArgs := 1, 1
+	if f, exists := c.lookupFunction(name); exists {
+		minArgs, maxArgs = len(f.params), len(f.params)
+	} else if builtin, exists := mathFuncs[name]; exists {
+		minArgs, maxArgs = builtin.minArgs, builtin.maxArgs
+	}
+
+	switch {
+	case count >= minArgs && (maxArgs == -1 || count <= maxArgs):
+		return nil
+	case minArgs == maxArgs:
+		return &ExpressionError{fmt.Sprintf("%s expects %d argument%s, got %d", name, minArgs, plural(minArgs), count), pos}
+	case maxArgs == -1:
+		return &ExpressionError{fmt.Sprintf("%s expects at least %d argument%s, got %d", name, minArgs, plural(minArgs), count), pos}
+	}
+	return &ExpressionError{fmt.Sprintf("%s expects %d to %d arguments, got %d", name, minArgs, maxArgs, count), pos}
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
+// CreateFunction compiles expr into a function of x, definitions ("name = ...") are registered instead.
+// In complex mode the function is evaluated over complex numbers and fails where its value isn't real.
+func (c *Calculator) CreateFunction(expr string) (func(float64) (float64, error), error) {
+	// Check if it's a definition, an "=" inside a call or braces, in <= or >=, or in an equation doesn't make one
+	if _, _, ok := splitDefinition(expr); ok {
+		if err := c.Define(expr); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	node, err := c.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.dimensionOf(node, nil); err != nil {
+		return nil, err
+	}
+
+	// If the expression has no standalone variable 'x', evaluate once to a constant
+	if !usesVariable(node, "x") {
+		val, err := c.evaluate(node, map[string]float64{"x": 0})
+		if err != nil {
+			return nil, err
+		}
+		return func(x float64) (float64, error) { return val, nil }, nil
+	}
+
+	return c.compile(node), nil
+}
+
+// Wraps an already parsed tree as a function of x
+func (c *Calculator) compile(node Node) func(float64) (float64, error) {
+	return func(x float64) (float64, error) {
+		return c.evaluate(node, map[string]float64{"x": x})
+	}
+}
+
+// Copies vars with name bound to value, leaving the caller's bindings untouched
+func withVariable(vars map[string]float64, name string, value float64) map[string]float64 {
+	scoped := make(map[string]float64, len(vars)+1)
+	for k, v := range vars {
+		scoped[k] = v
+	}
+	scoped[name] = value
+	return scoped
+}
+
+// eval walks the expression tree with the given variable bindings
+func (c *Calculator) eval(node Node, vars map[string]float64) (float64, error) {
+	switch n := node.(type) {
+	case *NumberNode:
+		return n.Value, nil
+
+	case *VariableNode:
+		val, exists := vars[n.Name]
+		if !exists {
+			return 0, &ExpressionError{fmt.Sprintf("unknown variable: %s", n.Name), n.Position}
+		}
+		return val, nil
+
+	case *ConstantNode:
+		if val, exists := mathConstants[n.Name]; exists {
+			return val, nil
+		}
+		if val, exists := c.constants[n.Name]; exists {
+			return val, nil
+		}
+		if val, exists := c.complexConstants[n.Name]; exists {
+			return 0, &ExpressionError{fmt.Sprintf("%s = %s is complex, turn on complex mode to use it", n.Name, formatComplex(val)), n.Position}
+		}
+		return 0, &ExpressionError{fmt.Sprintf("unknown constant: %s", n.Name), n.Position}
+
+	case *UnitNode:
+		return units[n.Name].factor, nil
+
+	case *IntegralNode:
+		value, _, err := c.evalIntegral(n, vars)
+		return value, err
+
+	case *SeriesNode:
+		return c.evalSeries(n, vars)
+
+	case *StatNode:
+		values, exists := c.column(n.Table, n.Column)
+		if !exists {
+			return 0, &ExpressionError{fmt.Sprintf("unknown table: %s", n.Table), n.Position}
+		}
+		value, err := statistics[n.Func](values)
+		if err != nil {
+			return 0, &ExpressionError{fmt.Sprintf("%s of %s.%s: %v", n.Func, n.Table, n.Column, err), n.Position}
+		}
+		return value, nil
+
+	case *UnaryNode:
+		a, err := c.eval(n.Operand, vars)
+		if err != nil {
+			return 0, err
+		}
+		return -a, nil
+
+	case *BinaryNode:
+		a, err := c.eval(n.Left, vars)
+		if err != nil {
+			return 0, err
+		}
+		b, err := c.eval(n.Right, vars)
+		if err != nil {
+			return 0, err
+		}
+
+		switch n.Op {
+		case "+":
+			return a + b, nil
+		case "-":
+			return a - b, nil
+		case "*":
+			return a * b, nil
+		case "/":
+			if b == 0 {
+				return 0, &ExpressionError{"division by zero", n.Position}
+			}
+			return a / b, nil
+		case "^":
+			result := math.Pow(a, b)
+			if math.IsNaN(result) || math.IsInf(result, 0) {
+				return 0, &ExpressionError{"invalid power operation result", n.Position}
+			}
+			return result, nil
+		}
+		return 0, &ExpressionError{fmt.Sprintf("unknown operator: %s", n.Op), n.Position}
+
+	case *CallNode:
+		return c.evalCall(n, vars)
+
+	case *TupleNode:
+		return 0, &ExpressionError{"a point " + n.String() + " cannot be used as a number", n.Position}
+
+	case *EquationNode:
+		return 0, &ExpressionError{"'=' can only appear once, between the two sides of an equation", n.Position}
+
+	case *ComparisonNode, *LogicalNode:
+		return 0, &ExpressionError{fmt.Sprintf("the condition %s cannot be used as a number", n), n.Pos()}
+
+	case *PiecewiseNode:
+		_, value, err := c.choosePiece(n, vars)
+		if err != nil {
+			return 0, err
+		}
+		return c.eval(value, vars)
+
+	case *DerivativeNode:
+		if n.Result != nil {
+			return c.eval(n.Result, vars)
+		}
+		return NumericalDerivative(func(x float64) (float64, error) {
+			return c.eval(n.Operand, withVariable(vars, "x", x))
+		}, vars["x"])
+	}
+	return 0, fmt.Errorf("unsupported expression node %T", node)
+}
+
+// evalCondition decides a comparison, or comparisons joined with and/or, which stop at the first side that settles them
+func (c *Calculator) evalCondition(node Node, vars map[string]float64) (bool, error) {
+	switch n := node.(type) {
+	case *ComparisonNode:
+		a, err := c.eval(n.Left, vars)
+		if err != nil {
+			return false, err
+		}
+		b, err := c.eval(n.Right, vars)
+		if err != nil {
+			return false, err
+		}
+		switch n.Op {
+		case "<":
+			return a < b, nil
+		case "<=":
+			return a <= b, nil
+		case ">":
+			return a > b, nil
+		case ">=":
+			return a >= b, nil
+		case "!=":
+			return a != b, nil
+		}
+		return false, &ExpressionError{fmt.Sprintf("unknown comparison: %s", n.Op), n.Position}
+
+	case *LogicalNode:
+		a, err := c.evalCondition(n.Left, vars)
+		if err != nil {
+			return false, err
+		}
+		if (n.Op == "and" && !a) || (n.Op == "or" && a) {
+			return a, nil
+		}
+		return c.evalCondition(n.Right, vars)
+	}
+	return false, &ExpressionError{fmt.Sprintf("expected a condition such as x < 2, got %s", node), node.Pos()}
+}
+
+// Finds the first piece whose condition holds, only its conditions up to that one are evaluated
+// and none of the other values, so an undefined piece elsewhere can't cause an error
+func (c *Calculator) choosePiece(n *PiecewiseNode, vars map[string]float64) (int, Node, error) {
+	for i, condition := range n.Conditions {
+		holds, err := c.evalCondition(condition, vars)
+		if err != nil {
+			return 0, nil, err
+		}
+		if holds {
+			return i, n.Values[i], nil
+		}
+	}
+	if n.Otherwise == nil {
+		return 0, nil, &ExpressionError{"no piece of the piecewise expression applies", n.Position}
+	}
+	return len(n.Conditions), n.Otherwise, nil
+}
+
+// Identifies which piece of every piecewise expression in node applies at vars, following calls into
+// user functions, so a plot can tell two neighbouring samples from different pieces apart and not join them
+func (c *Calculator) pieceKey(node Node, vars map[string]float64) int {
+	switch n := node.(type) {
+	case *PiecewiseNode:
+		index, value, err := c.choosePiece(n, vars)
+		if err != nil {
+			return -1
+		}
+		return (index+1)*31 + c.pieceKey(value, vars)
+	case *UnaryNode:
+		return c.pieceKey(n.Operand, vars)
+	case *BinaryNode:
+		return c.pieceKey(n.Left, vars)*31 + c.pieceKey(n.Right, vars)
+	case *DerivativeNode:
+		if n.Result != nil {
+			return c.pieceKey(n.Result, vars)
+		}
+		return c.pieceKey(n.Operand, vars)
+	case *CallNode:
+		key := 0
+		for _, arg := range n.Args {
+			key = key*31 + c.pieceKey(arg, vars)
+		}
+		f, exists := c.lookupFunction(n.Name)
+		if !exists || f.body == nil || len(f.params) != len(n.Args) {
+			return key
+		}
+		scoped := make(map[string]float64, len(f.params))
+		for i, param := range f.params {
+			value, err := c.eval(n.Args[i], vars)
+			if err != nil {
+				return key
+			}
+			scoped[param] = value
+		}
+		return key*31 + c.pieceKey(f.body, scoped)
+	}
+	return 0
+}
+
+func (c *Calculator) evalCall(n *CallNode, vars map[string]float64) (float64, error) {
+	args := make([]float64, len(n.Args))
+	for i, arg := range n.Args {
+		val, err := c.eval(arg, vars)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = val
+	}
+
+	if f, exists := c.lookupFunction(n.Name); exists {
+		// The function may have been redefined with different parameters since this call was parsed
+		if err := c.checkArity(n.Name, len(args), n.Position); err != nil {
+			return 0, err
+		}
+		return f.fn(args)
+	}
+
+	builtin, exists := mathFuncs[n.Name]
+	if !exists || builtin.call == nil {
+		return 0, &ExpressionError{fmt.Sprintf("unknown function: %s", n.Name), n.Position}
+	}
+	if err := c.checkArity(n.Name, len(args), n.Position); err != nil {
+		return 0, err
+	}
+	result, err := builtin.call(args)
+	if err != nil {
+		return 0, &ExpressionError{err.Error(), n.Position}
+	}
+
+	// Check for general domain errors
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return 0, &ExpressionError{fmt.Sprintf("domain error: %s(%s) produced an invalid result", n.Name, formatArgs(args)), n.Position}
+	}
+	return result, nil
+}
+
+func formatArgs(args []float64) string {
+	parts := make([]string, len(args))
+	for i, a := range args {
+		parts[i] = fmt.Sprintf("%f", a)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,91 @@
+package modules
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestPiecewise(t *testing.T) {
+	tests := []struct {
+		expr string
+		x    float64
+		want float64
+	}{
+		{"{x < 0: -x, x <= 2: x^2, 10}", -1, 1},
+		{"{x < 0: -x, x <= 2: x^2, 10}", 0, 0}, // x < 0 no longer holds at 0 itself
+		{"{x < 0: -x, x <= 2: x^2, 10}", 2, 4}, // but x <= 2 still does at 2
+		{"{x < 0: -x, x <= 2: x^2, 10}", math.Nextafter(2, 3), 10},
+		{"piecewise(x != 1, 2x, 7)", 1, 7},
+		{"piecewise(x != 1, 2x, 7)", 3, 6},
+		{"{x > 0: ln(x), 0}", -1, 0}, // the piece not taken is never evaluated
+	}
+	c := NewCalculator()
+	for _, test := range tests {
+		f, err := c.CreateFunction(test.expr)
+		if err != nil {
+			t.Fatalf("CreateFunction(%s): %v", test.expr, err)
+		}
+		if got, err := f(test.x); err != nil || got != test.want {
+			t.Errorf("%s at %g = %g, %v, want %g", test.expr, test.x, got, err, test.want)
+		}
+	}
+}
+
+// Where no condition holds and there is no otherwise value there is no value at all, not 0
+func TestPiecewiseNoPiece(t *testing.T) {
+	c := NewCalculator()
+	for _, expr := range []string{"{x < 0: 1}", "piecewise(x < 0, 1, x > 5, 2)"} {
+		f, err := c.CreateFunction(expr)
+		if err != nil {
+			t.Fatalf("CreateFunction(%s): %v", expr, err)
+		}
+		got, err := f(1)
+		if e, ok := err.(*ExpressionError); !ok || !strings.Contains(e.Message, "no piece") {
+			t.Errorf("%s at 1 = %g, %v, want the no piece error", expr, got, err)
+		}
+	}
+}
+
+// The plot lifts its pen where the key changes, so it has to change right at each boundary and
+// nowhere else, including inside a user function that is piecewise
+func TestPieceKey(t *testing.T) {
+	c := NewCalculator()
+	if err := c.Define("f(x) = {x < 1: 0, 1}"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		expr       string
+		boundaries []float64 // in increasing order, the key at each is the one to its right unless closed says otherwise
+		closed     []bool    // whether the boundary belongs to the piece on its left, as with x <= 2
+	}{
+		{"{x < 0: -x, x <= 2: x^2, 10}", []float64{0, 2}, []bool{false, true}},
+		{"3 + 2 * f(x)", []float64{1}, []bool{false}},
+		{"f(x - 2) + {x >= 0: x, -x}", []float64{0, 3}, []bool{false, false}},
+	}
+	for _, test := range tests {
+		node, err := c.Parse(test.expr)
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", test.expr, err)
+		}
+		key := func(x float64) int { return c.pieceKey(node, map[string]float64{"x": x}) }
+		for i, at := range test.boundaries {
+			below, above := math.Nextafter(at, math.Inf(-1)), math.Nextafter(at, math.Inf(1))
+			if key(below) == key(above) {
+				t.Errorf("%s: the piece key doesn't change across %g", test.expr, at)
+			}
+			if closed := key(at) == key(below); closed != test.closed[i] {
+				t.Errorf("%s: the key at %g matches the left side: %v, want %v", test.expr, at, closed, test.closed[i])
+			}
+			// Between boundaries the key stays the same
+			if i+1 < len(test.boundaries) {
+				next := test.boundaries[i+1]
+				for _, x := range []float64{above, (at + next) / 2, math.Nextafter(next, math.Inf(-1))} {
+					if key(x) != key(above) {
+						t.Errorf("%s: the piece key changes at %g, between boundaries %g and %g", test.expr, x, at, next)
+					}
+				}
+			}
+		}
+	}
+}
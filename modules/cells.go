@@ -0,0 +1,150 @@
+package modules
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// How the graph is put on the terminal
+type renderer int
+
+const (
+	imageRenderer     renderer = iota // an 800×600 image that tview scales down to the cells
+	brailleRenderer                   // a braille character per cell, 2×4 dots in one color
+	halfBlockRenderer                 // an upper half block per cell, its two halves colored apart
+)
+
+var rendererNames = []string{"Image", "Braille", "Blocks"}
+
+var graphRenderer = imageRenderer
+
+// Pixels of the graph drawn into each terminal cell by a cell renderer
+func (r renderer) dots() (across, down int) {
+	if r == brailleRenderer {
+		return 2, 4
+	}
+	return 1, 2
+}
+
+// Bits of the braille pattern for each dot of a cell, indexed [down][across]
+var brailleBits = [4][2]rune{{0x01, 0x08}, {0x02, 0x10}, {0x04, 0x20}, {0x40, 0x80}}
+
+const brailleBlank = '⠀'
+
+// The graph, shown as an image or drawn straight into terminal cells
+type graphView struct {
+	*tview.Box
+	image *tview.Image
+	cells *image.RGBA // the graph at the resolution of the cell renderer, nil when it has to be drawn again
+	drag  drag        // the mouse drag under way, if any
+}
+
+func newGraphView() *graphView {
+	return &graphView{
+		Box: tview.NewBox(),
+		image: tview.NewImage().
+			SetDithering(tview.DitheringNone).
+			SetColors(tview.TrueColor).
+			SetSize(-100, -100), // stretched over the whole view so the tick labels line up with it
+	}
+}
+
+func (g *graphView) Draw(screen tcell.Screen) {
+	g.Box.DrawForSubclass(screen, g)
+	left, top, width, height := g.GetInnerRect()
+	if graphRenderer == imageRenderer {
+		g.image.SetRect(left, top, width, height)
+		g.image.Draw(screen)
+	} else if width > 0 && height > 0 {
+		g.drawCells(screen, left, top, width, height)
+	}
+	drawLabels(screen, left, top, width, height)
+	g.drawSelection(screen)
+}
+
+// Draws the graph a braille character or half block to each cell
+func (g *graphView) drawCells(screen tcell.Screen, left, top, width, height int) {
+	across, down := graphRenderer.dots()
+	if g.cells == nil || g.cells.Bounds().Dx() != width*across || g.cells.Bounds().Dy() != height*down {
+		g.cells = renderCells(width*across, height*down)
+	}
+	for row := 0; row < height; row++ {
+		for col := 0; col < width; col++ {
+			var char rune
+			var style tcell.Style
+			if graphRenderer == brailleRenderer {
+				char, style = brailleCell(g.cells, col*across, row*down)
+			} else {
+				char, style = halfBlockCell(g.cells, col, row*down)
+			}
+			screen.SetContent(left+col, top+row, char, nil, style)
+		}
+	}
+}
+
+// Draws the graph for the cell renderers, a pixel to each braille dot or half block and lines one pixel wide
+func renderCells(width, height int) *image.RGBA {
+	savedWidth, savedHeight := lastImageWidth, lastImageHeight
+	lastImageWidth, lastImageHeight, stroke = width, height, cellStrokes
+	defer func() {
+		lastImageWidth, lastImageHeight, stroke = savedWidth, savedHeight, imageStrokes
+	}()
+	return createGraph().(*image.RGBA)
+}
+
+// Whether a pixel is drawn over, rather than blank or only tinted by a shaded region
+func isInk(c color.RGBA) bool {
+	return min(c.R, c.G, c.B) < 150 || isGridColor(c)
+}
+
+func isGridColor(c color.RGBA) bool {
+	return c == gridColor || c == minorGridColor
+}
+
+// A braille cell from the 2×4 pixels at left, top. Drawn pixels become dots in the color most of
+// them share, grid lines only lending theirs when nothing else is drawn there, and the rest
+// average into the background so shaded regions still show.
+func brailleCell(img *image.RGBA, left, top int) (rune, tcell.Style) {
+	char := brailleBlank
+	inks := map[color.RGBA]int{}
+	var ink color.RGBA
+	var r, g, b, blanks int
+	weight := func(c color.RGBA) int {
+		if isGridColor(c) {
+			return inks[c]
+		}
+		return inks[c] + len(brailleBits)*2 // more than any grid color can have
+	}
+	for dy, bits := range brailleBits {
+		for dx, bit := range bits {
+			c := img.RGBAAt(left+dx, top+dy)
+			if !isInk(c) {
+				r, g, b, blanks = r+int(c.R), g+int(c.G), b+int(c.B), blanks+1
+				continue
+			}
+			char |= bit
+			inks[c]++
+			if weight(c) > weight(ink) {
+				ink = c
+			}
+		}
+	}
+	background := tcell.ColorWhite
+	if blanks > 0 {
+		background = tcell.NewRGBColor(int32(r/blanks), int32(g/blanks), int32(b/blanks))
+	}
+	return char, tcell.StyleDefault.Background(background).Foreground(toCellColor(ink))
+}
+
+// A half block cell from the two pixels at col, top, the upper half takes the foreground color
+func halfBlockCell(img *image.RGBA, col, top int) (rune, tcell.Style) {
+	upper, lower := img.RGBAAt(col, top), img.RGBAAt(col, top+1)
+	return '▀', tcell.StyleDefault.Foreground(toCellColor(upper)).Background(toCellColor(lower))
+}
+
+func toCellColor(c color.RGBA) tcell.Color {
+	return tcell.NewRGBColor(int32(c.R), int32(c.G), int32(c.B))
+}
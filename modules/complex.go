@@ -0,0 +1,335 @@
+package modules
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strconv"
+)
+
+// Built in functions with their own complex definitions, every other built in is
+// evaluated through its real version and needs real arguments in complex mode
+var complexFuncs = map[string]func(args []complex128) (complex128, error){
+	"sin":  unaryComplex(cmplx.Sin),
+	"cos":  unaryComplex(cmplx.Cos),
+	"tan":  unaryComplex(cmplx.Tan),
+	"sqrt": unaryComplex(cmplx.Sqrt),
+	"ln": func(args []complex128) (complex128, error) {
+		if args[0] == 0 {
+			return 0, fmt.Errorf("domain error: ln(0) - logarithm of zero")
+		}
+		return cmplx.Log(args[0]), nil
+	},
+	// log(z) is base 10, log(b, z) is base b
+	"log": func(args []complex128) (complex128, error) {
+		base, z := complex(10, 0), args[0]
+		if len(args) == 2 {
+			base, z = args[0], args[1]
+		}
+		if base == 0 || base == 1 {
+			return 0, fmt.Errorf("domain error: log base %s - base must not be 0 or 1", formatComplex(base))
+		}
+		if z == 0 {
+			return 0, fmt.Errorf("domain error: log(0) - logarithm of zero")
+		}
+		if base == 10 {
+			return cmplx.Log10(z), nil
+		}
+		return cmplx.Log(z) / cmplx.Log(base), nil
+	},
+	"exp":  unaryComplex(cmplx.Exp),
+	"abs":  func(args []complex128) (complex128, error) { return complex(cmplx.Abs(args[0]), 0), nil },
+	"asin": unaryComplex(cmplx.Asin),
+	"acos": unaryComplex(cmplx.Acos),
+	"atan": unaryComplex(cmplx.Atan),
+	"arg":  func(args []complex128) (complex128, error) { return complex(cmplx.Phase(args[0]), 0), nil },
+	"re":   func(args []complex128) (complex128, error) { return complex(real(args[0]), 0), nil },
+	"im":   func(args []complex128) (complex128, error) { return complex(imag(args[0]), 0), nil },
+	"conj": unaryComplex(cmplx.Conj),
+}
+
+func unaryComplex(f func(complex128) complex128) func(args []complex128) (complex128, error) {
+	return func(args []complex128) (complex128, error) { return f(args[0]), nil }
+}
+
+// SetComplex switches the calculator between real and complex evaluation. In complex mode i is the
+// imaginary unit, square roots and logarithms of negative numbers are defined, and real valued
+// helpers such as CreateFunction only fail where a result has an imaginary part.
+func (c *Calculator) SetComplex(enabled bool) {
+	c.complexMode = enabled
+}
+
+// Reports whether the calculator evaluates over complex numbers
+func (c *Calculator) IsComplex() bool {
+	return c.complexMode
+}
+
+// Registers (or replaces) a user constant with a complex value, real values are stored as ordinary constants
+func (c *Calculator) SetComplexConstant(name string, value complex128) {
+	if imag(value) == 0 {
+		c.SetConstant(name, real(value))
+		return
+	}
+	delete(c.constants, name)
+	delete(c.constantNodes, name)
+	delete(c.constantUnits, name)
+	c.complexConstants[name] = value
+}
+
+// CreateComplexFunction compiles expr into a function of a complex x, regardless of the current mode
+func (c *Calculator) CreateComplexFunction(expr string) (func(complex128) (complex128, error), error) {
+	node, err := c.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	return func(x complex128) (complex128, error) {
+		return c.evalComplex(node, map[string]complex128{"x": x})
+	}, nil
+}
+
+// evaluate is eval in the calculator's current mode, in complex mode the result has to come out real
+func (c *Calculator) evaluate(node Node, vars map[string]float64) (float64, error) {
+	if !c.complexMode {
+		return c.eval(node, vars)
+	}
+	complexVars := make(map[string]complex128, len(vars))
+	for name, value := range vars {
+		complexVars[name] = complex(value, 0)
+	}
+	z, err := c.evalComplex(node, complexVars)
+	if err != nil {
+		return 0, err
+	}
+	if z = cleanComplex(z); imag(z) != 0 {
+		return 0, &ExpressionError{fmt.Sprintf("result %s is not real", formatComplex(z)), node.Pos()}
+	}
+	return real(z), nil
+}
+
+// evalComplex walks the expression tree over complex numbers
+func (c *Calculator) evalComplex(node Node, vars map[string]complex128) (complex128, error) {
+	switch n := node.(type) {
+	case *NumberNode:
+		return complex(n.Value, 0), nil
+
+	case *VariableNode:
+		val, exists := vars[n.Name]
+		if !exists {
+			return 0, &ExpressionError{fmt.Sprintf("unknown variable: %s", n.Name), n.Position}
+		}
+		return val, nil
+
+	case *ConstantNode:
+		if n.Name == "i" {
+			return 1i, nil
+		}
+		if val, exists := c.complexConstants[n.Name]; exists {
+			return val, nil
+		}
+		val, err := c.eval(n, nil)
+		return complex(val, 0), err
+
+	case *UnaryNode:
+		a, err := c.evalComplex(n.Operand, vars)
+		if err != nil {
+			return 0, err
+		}
+		// 0 - a keeps a zero imaginary part positive, -a would put sqrt(-1) on the wrong side of the branch cut
+		return 0 - a, nil
+
+	case *BinaryNode:
+		a, err := c.evalComplex(n.Left, vars)
+		if err != nil {
+			return 0, err
+		}
+		b, err := c.evalComplex(n.Right, vars)
+		if err != nil {
+			return 0, err
+		}
+
+		switch n.Op {
+		case "+":
+			return a + b, nil
+		case "-":
+			return a - b, nil
+		case "*":
+			return a * b, nil
+		case "/":
+			if b == 0 {
+				return 0, &ExpressionError{"division by zero", n.Position}
+			}
+			return a / b, nil
+		case "^":
+			// Real powers with a real result keep math.Pow's exactness, 2^3 is 8 rather than 7.999...
+			var result complex128
+			if imag(a) == 0 && imag(b) == 0 && (real(a) >= 0 || real(b) == math.Trunc(real(b))) {
+				result = complex(math.Pow(real(a), real(b)), 0)
+			} else {
+				result = cmplx.Pow(a, b)
+			}
+			if cmplx.IsNaN(result) || cmplx.IsInf(result) {
+				return 0, &ExpressionError{"invalid power operation result", n.Position}
+			}
+			return result, nil
+		}
+		return 0, &ExpressionError{fmt.Sprintf("unknown operator: %s", n.Op), n.Position}
+
+	case *CallNode:
+		return c.evalComplexCall(n, vars)
+
+	case *DerivativeNode:
+		if n.Result != nil {
+			return c.evalComplex(n.Result, vars)
+		}
+		realVars, err := realParts(vars, n.Position)
+		if err != nil {
+			return 0, err
+		}
+		val, err := c.eval(n, realVars)
+		return complex(val, 0), err
+
+	case *SeriesNode:
+		return c.evalComplexSeries(n, vars)
+
+	case *IntegralNode:
+		// Integrals are taken along the real line, of integrands that come out real there
+		realVars, err := realParts(vars, n.Position)
+		if err != nil {
+			return 0, err
+		}
+		val, err := c.eval(n, realVars)
+		return complex(val, 0), err
+
+	case *PiecewiseNode:
+		// Conditions compare real numbers, the chosen piece may still be complex
+		realVars, err := realParts(vars, n.Position)
+		if err != nil {
+			return 0, err
+		}
+		_, value, err := c.choosePiece(n, realVars)
+		if err != nil {
+			return 0, err
+		}
+		return c.evalComplex(value, vars)
+	}
+
+	// Tuples, equations and conditions are no more numbers here than in real mode
+	val, err := c.eval(node, nil)
+	return complex(val, 0), err
+}
+
+func (c *Calculator) evalComplexCall(n *CallNode, vars map[string]complex128) (complex128, error) {
+	args := make([]complex128, len(n.Args))
+	for i, arg := range n.Args {
+		val, err := c.evalComplex(arg, vars)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = val
+	}
+	if err := c.checkArity(n.Name, len(args), n.Position); err != nil {
+		return 0, err
+	}
+
+	if f, exists := c.lookupFunction(n.Name); exists {
+		if f.body != nil {
+			scoped := make(map[string]complex128, len(f.params))
+			for i, param := range f.params {
+				scoped[param] = args[i]
+			}
+			return c.evalComplex(f.body, scoped)
+		}
+		// Functions registered as Go code only know real numbers
+		realArgs, err := realArguments(n.Name, args, n.Position)
+		if err != nil {
+			return 0, err
+		}
+		val, err := f.fn(realArgs)
+		return complex(val, 0), err
+	}
+
+	var result complex128
+	if call, exists := complexFuncs[n.Name]; exists {
+		val, err := call(args)
+		if err != nil {
+			return 0, &ExpressionError{err.Error(), n.Position}
+		}
+		result = val
+	} else {
+		builtin, exists := mathFuncs[n.Name]
+		if !exists || builtin.call == nil {
+			return 0, &ExpressionError{fmt.Sprintf("unknown function: %s", n.Name), n.Position}
+		}
+		realArgs, err := realArguments(n.Name, args, n.Position)
+		if err != nil {
+			return 0, err
+		}
+		val, err := builtin.call(realArgs)
+		if err != nil {
+			return 0, &ExpressionError{err.Error(), n.Position}
+		}
+		result = complex(val, 0)
+	}
+
+	if cmplx.IsNaN(result) || cmplx.IsInf(result) {
+		return 0, &ExpressionError{fmt.Sprintf("domain error: %s produced an invalid result", n.Name), n.Position}
+	}
+	return result, nil
+}
+
+// Converts the arguments of a function that is only defined for real numbers
+func realArguments(name string, args []complex128, pos int) ([]float64, error) {
+	realArgs := make([]float64, len(args))
+	for i, arg := range args {
+		if imag(arg) != 0 {
+			return nil, &ExpressionError{fmt.Sprintf("%s needs real arguments, got %s", name, formatComplex(arg)), pos}
+		}
+		realArgs[i] = real(arg)
+	}
+	return realArgs, nil
+}
+
+// Converts variable bindings for the parts of an expression that only make sense for real numbers
+func realParts(vars map[string]complex128, pos int) (map[string]float64, error) {
+	realVars := make(map[string]float64, len(vars))
+	for name, value := range vars {
+		if imag(value) != 0 {
+			return nil, &ExpressionError{fmt.Sprintf("%s = %s is not real", name, formatComplex(value)), pos}
+		}
+		realVars[name] = real(value)
+	}
+	return realVars, nil
+}
+
+// Drops a part that is only rounding error next to the other one, so e^(i*pi) comes out as -1 rather than -1 + 1.2e-16i
+func cleanComplex(z complex128) complex128 {
+	const tolerance = 1e-12
+	re, im := real(z), imag(z)
+	if math.Abs(im) < tolerance*math.Max(1, math.Abs(re)) {
+		im = 0
+	}
+	if math.Abs(re) < tolerance*math.Max(1, math.Abs(im)) {
+		re = 0
+	}
+	return complex(re, im)
+}
+
+// Formats z as "a + bi", leaving out a part that is zero
+func formatComplex(z complex128) string {
+	re, im := real(z), imag(z)
+	imText := strconv.FormatFloat(math.Abs(im), 'g', -1, 64) + "i"
+	if math.Abs(im) == 1 {
+		imText = "i"
+	}
+	switch {
+	case im == 0:
+		return strconv.FormatFloat(re, 'g', -1, 64)
+	case re == 0 && im < 0:
+		return "-" + imText
+	case re == 0:
+		return imText
+	case im < 0:
+		return strconv.FormatFloat(re, 'g', -1, 64) + " - " + imText
+	}
+	return strconv.FormatFloat(re, 'g', -1, 64) + " + " + imText
+}
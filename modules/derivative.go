@@ -0,0 +1,568 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Evaluates the derivative at a point using a fourth-order central difference formula
+func NumericalDerivative(f func(float64) (float64, error), x float64) (float64, error) {
+	// Calculate optimal step size based on x
+	h := math.Pow(2.2e-16, 1.0/5.0) * math.Max(1.0, math.Abs(x))
+
+	a, err1 := f(x + 2*h)
+	b, err2 := f(x + h)
+	c, err3 := f(x - h)
+	d, err4 := f(x - 2*h)
+
+	if _, err0 := f(x); err0 != nil || err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return 0, errors.New("Derivative does not exist at this point")
+	}
+	// Fourth-order central difference formula
+	return (-a + 8*b - 8*c + d) / (12 * h), nil
+}
+
+// Derivative returns the derivative of expr with respect to x
+func (c *Calculator) Derivative(expr string) (func(float64) (float64, error), error) {
+	node, err := c.Differentiate(expr)
+	if err != nil {
+		return nil, err
+	}
+	return c.compile(node), nil
+}
+
+// Differentiate returns the simplified symbolic derivative of expr with respect to x
+func (c *Calculator) Differentiate(expr string) (Node, error) {
+	node, err := c.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	d, err := differentiate(node, "x")
+	if err != nil {
+		return nil, err
+	}
+	return simplify(d), nil
+}
+
+// Derivatives of the built in functions with respect to their argument u, the chain rule is applied by the caller
+var derivativeRules = map[string]func(u Node, pos int) Node{
+	"sin": func(u Node, pos int) Node { return call("cos", pos, u) },
+	"cos": func(u Node, pos int) Node { return negate(call("sin", pos, u), pos) },
+	"tan": func(u Node, pos int) Node {
+		return binary("/", number(1, pos), binary("^", call("cos", pos, u), number(2, pos), pos), pos)
+	},
+	"sqrt": func(u Node, pos int) Node {
+		return binary("/", number(1, pos), binary("*", number(2, pos), call("sqrt", pos, u), pos), pos)
+	},
+	"ln":  func(u Node, pos int) Node { return binary("/", number(1, pos), u, pos) },
+	"exp": func(u Node, pos int) Node { return call("exp", pos, u) },
+	"abs": func(u Node, pos int) Node { return binary("/", u, call("abs", pos, u), pos) },
+	"asin": func(u Node, pos int) Node {
+		return binary("/", number(1, pos), call("sqrt", pos, binary("-", number(1, pos), binary("^", u, number(2, pos), pos), pos)), pos)
+	},
+	"acos": func(u Node, pos int) Node {
+		return binary("/", number(-1, pos), call("sqrt", pos, binary("-", number(1, pos), binary("^", u, number(2, pos), pos), pos)), pos)
+	},
+	"atan": func(u Node, pos int) Node {
+		return binary("/", number(1, pos), binary("+", number(1, pos), binary("^", u, number(2, pos), pos), pos), pos)
+	},
+	// step functions are flat everywhere they are differentiable
+	"floor": func(u Node, pos int) Node { return number(0, pos) },
+	"ceil":  func(u Node, pos int) Node { return number(0, pos) },
+	"round": func(u Node, pos int) Node { return number(0, pos) },
+}
+
+// Multi argument built ins are differentiated through an equivalent expression built from the rules above,
+// min, max and clamp have none and fall back to numerical differentiation
+var derivativeRewrites = map[string]func(args []Node, pos int) Node{
+	"log": func(args []Node, pos int) Node {
+		if len(args) == 1 {
+			return binary("/", call("ln", pos, args[0]), call("ln", pos, number(10, pos)), pos)
+		}
+		return binary("/", call("ln", pos, args[1]), call("ln", pos, args[0]), pos)
+	},
+	"hypot": func(args []Node, pos int) Node {
+		squares := binary("+", binary("^", args[0], number(2, pos), pos), binary("^", args[1], number(2, pos), pos), pos)
+		return call("sqrt", pos, squares)
+	},
+	// atan(y/x) differs from atan2(y, x) by a constant on each half plane, so their derivatives agree
+	"atan2": func(args []Node, pos int) Node {
+		return call("atan", pos, binary("/", args[0], args[1], pos))
+	},
+	"mod": func(args []Node, pos int) Node {
+		return binary("-", args[0], binary("*", args[1], call("floor", pos, binary("/", args[0], args[1], pos)), pos), pos)
+	},
+}
+
+// differentiate returns d(node)/d(variable) as a new, unsimplified tree.
+// Calls to user functions become calls to their primed derivative (f -> f'),
+// which are resolved when evaluated so later redefinitions are picked up.
+func differentiate(node Node, variable string) (Node, error) {
+	pos := node.Pos()
+	switch n := node.(type) {
+	case *NumberNode, *ConstantNode, *UnitNode, *StatNode:
+		return number(0, pos), nil
+
+	case *VariableNode:
+		if n.Name == variable {
+			return number(1, pos), nil
+		}
+		return number(0, pos), nil
+
+	case *UnaryNode:
+		d, err := differentiate(n.Operand, variable)
+		if err != nil {
+			return nil, err
+		}
+		return negate(d, pos), nil
+
+	case *BinaryNode:
+		u, v := n.Left, n.Right
+		du, err := differentiate(u, variable)
+		if err != nil {
+			return nil, err
+		}
+		dv, err := differentiate(v, variable)
+		if err != nil {
+			return nil, err
+		}
+
+		switch n.Op {
+		case "+", "-":
+			return binary(n.Op, du, dv, pos), nil
+		case "*":
+			return binary("+", binary("*", du, v, pos), binary("*", u, dv, pos), pos), nil
+		case "/":
+			if !usesVariable(v, variable) {
+				return binary("/", du, v, pos), nil
+			}
+			numerator := binary("-", binary("*", du, v, pos), binary("*", u, dv, pos), pos)
+			return binary("/", numerator, binary("^", v, number(2, pos), pos), pos), nil
+		case "^":
+			if !usesVariable(v, variable) {
+				// Power rule: (u^n)' = n * u^(n-1) * u'
+				power := binary("^", u, binary("-", v, number(1, pos), pos), pos)
+				return binary("*", binary("*", v, power, pos), du, pos), nil
+			}
+			if constant, ok := u.(*ConstantNode); ok && constant.Name == "e" {
+				return binary("*", node, dv, pos), nil
+			}
+			if !usesVariable(u, variable) {
+				// (a^v)' = a^v * ln(a) * v'
+				return binary("*", binary("*", node, call("ln", pos, u), pos), dv, pos), nil
+			}
+			// (u^v)' = u^v * (v' * ln(u) + v * u' / u)
+			inner := binary("+",
+				binary("*", dv, call("ln", pos, u), pos),
+				binary("/", binary("*", v, du, pos), u, pos), pos)
+			return binary("*", node, inner, pos), nil
+		}
+		return nil, &ExpressionError{fmt.Sprintf("cannot differentiate operator %s", n.Op), pos}
+
+	case *DerivativeNode:
+		if n.Result == nil {
+			return nil, &ExpressionError{"cannot differentiate d/dx symbolically", pos}
+		}
+		return differentiate(n.Result, variable)
+
+	case *SeriesNode:
+		if !usesVariable(n, variable) {
+			return number(0, pos), nil
+		}
+		// A sum is differentiated term by term, as long as its bounds stay put
+		if n.Op == "sum" && n.Index != variable && !usesVariable(n.From, variable) && !usesVariable(n.To, variable) {
+			d, err := differentiate(n.Body, variable)
+			if err != nil {
+				return nil, err
+			}
+			return &SeriesNode{Op: n.Op, Index: n.Index, From: n.From, To: n.To, Body: d, Position: pos}, nil
+		}
+		return nil, &ExpressionError{fmt.Sprintf("cannot differentiate this %s symbolically", n.Op), pos}
+
+	case *IntegralNode:
+		if !usesVariable(n, variable) {
+			return number(0, pos), nil
+		}
+		// An integral from a fixed point up to the variable it is taken over gives back its body
+		if upper, ok := n.Upper.(*VariableNode); ok && upper.Name == variable && n.Variable == variable && !usesVariable(n.Lower, variable) {
+			return n.Body, nil
+		}
+		return nil, &ExpressionError{"cannot differentiate this integral symbolically", pos}
+
+	case *PiecewiseNode:
+		// Each piece is differentiated on its own, the jumps between them are left out
+		d := &PiecewiseNode{Conditions: n.Conditions, Position: pos}
+		for _, value := range n.Values {
+			dv, err := differentiate(value, variable)
+			if err != nil {
+				return nil, err
+			}
+			d.Values = append(d.Values, dv)
+		}
+		if n.Otherwise != nil {
+			do, err := differentiate(n.Otherwise, variable)
+			if err != nil {
+				return nil, err
+			}
+			d.Otherwise = do
+		}
+		return d, nil
+
+	case *CallNode:
+		// Built ins of constants are constant, even those without a rule such as factorial: (2k+1)!
+		if _, builtin := mathFuncs[n.Name]; builtin && !usesVariable(n, variable) {
+			return number(0, pos), nil
+		}
+		if rewrite, exists := derivativeRewrites[n.Name]; exists {
+			return differentiate(rewrite(n.Args, pos), variable)
+		}
+		// f' is the derivative in the first parameter, so the other arguments must not vary
+		for _, arg := range n.Args[1:] {
+			if usesVariable(arg, variable) {
+				return nil, &ExpressionError{fmt.Sprintf("cannot differentiate %s symbolically", n.Name), pos}
+			}
+		}
+		u := n.Args[0]
+		du, err := differentiate(u, variable)
+		if err != nil {
+			return nil, err
+		}
+
+		var outer Node
+		if rule, exists := derivativeRules[n.Name]; exists {
+			outer = rule(u, pos)
+		} else if _, builtin := mathFuncs[n.Name]; builtin {
+			return nil, &ExpressionError{fmt.Sprintf("cannot differentiate %s symbolically", n.Name), pos}
+		} else {
+			outer = call(n.Name+"'", pos, n.Args...)
+		}
+		return binary("*", outer, du, pos), nil
+	}
+	return nil, fmt.Errorf("cannot differentiate expression node %T", node)
+}
+
+// Resolves a user function by name, every trailing ' refers to one more derivative of it
+// with respect to its first parameter.
+// Derivatives are built symbolically from the function body where there is one and
+// numerically otherwise, then cached until the next function is (re)defined.
+func (c *Calculator) lookupFunction(name string) (userFunction, bool) {
+	if f, exists := c.functions[name]; exists {
+		return f, true
+	}
+	if !strings.HasSuffix(name, "'") {
+		return userFunction{}, false
+	}
+	if f, exists := c.derived[name]; exists {
+		return f, true
+	}
+
+	base, exists := c.lookupFunction(strings.TrimSuffix(name, "'"))
+	if !exists {
+		return userFunction{}, false
+	}
+	var f userFunction
+	if base.body != nil {
+		if d, err := differentiate(base.body, base.params[0]); err == nil {
+			f = c.newUserFunction(base.params, simplify(d))
+		}
+	}
+	if f.fn == nil {
+		baseFn := base.fn
+		f = userFunction{params: base.params, fn: func(args []float64) (float64, error) {
+			rest := args[1:]
+			return NumericalDerivative(func(x float64) (float64, error) {
+				return baseFn(append([]float64{x}, rest...))
+			}, args[0])
+		}}
+	}
+	c.derived[name] = f
+	return f, true
+}
+
+// Replaces every d/dx(...) in the tree with its symbolic result, for display
+func expandDerivatives(node Node) Node {
+	switch n := node.(type) {
+	case *DerivativeNode:
+		if n.Result == nil {
+			return n
+		}
+		return expandDerivatives(n.Result)
+	case *UnaryNode:
+		return &UnaryNode{Op: n.Op, Operand: expandDerivatives(n.Operand), Position: n.Position}
+	case *BinaryNode:
+		return &BinaryNode{Op: n.Op, Left: expandDerivatives(n.Left), Right: expandDerivatives(n.Right), Position: n.Position}
+	case *CallNode:
+		args := make([]Node, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = expandDerivatives(arg)
+		}
+		return &CallNode{Name: n.Name, Args: args, Position: n.Position}
+	case *IntegralNode:
+		return &IntegralNode{Body: expandDerivatives(n.Body), Variable: n.Variable, Lower: expandDerivatives(n.Lower), Upper: expandDerivatives(n.Upper), Position: n.Position}
+	case *SeriesNode:
+		return &SeriesNode{Op: n.Op, Index: n.Index, From: expandDerivatives(n.From), To: expandDerivatives(n.To), Body: expandDerivatives(n.Body), Position: n.Position}
+	case *TupleNode:
+		items := make([]Node, len(n.Items))
+		for i, item := range n.Items {
+			items[i] = expandDerivatives(item)
+		}
+		return &TupleNode{Items: items, Position: n.Position}
+	case *EquationNode:
+		return &EquationNode{Left: expandDerivatives(n.Left), Right: expandDerivatives(n.Right), Position: n.Position}
+	case *ComparisonNode:
+		return &ComparisonNode{Op: n.Op, Left: expandDerivatives(n.Left), Right: expandDerivatives(n.Right), Position: n.Position}
+	case *LogicalNode:
+		return &LogicalNode{Op: n.Op, Left: expandDerivatives(n.Left), Right: expandDerivatives(n.Right), Position: n.Position}
+	case *PiecewiseNode:
+		expanded := &PiecewiseNode{Position: n.Position}
+		for i, condition := range n.Conditions {
+			expanded.Conditions = append(expanded.Conditions, expandDerivatives(condition))
+			expanded.Values = append(expanded.Values, expandDerivatives(n.Values[i]))
+		}
+		if n.Otherwise != nil {
+			expanded.Otherwise = expandDerivatives(n.Otherwise)
+		}
+		return expanded
+	}
+	return node
+}
+
+// Reports whether the tree contains a d/dx anywhere
+func containsDerivative(node Node) bool {
+	switch n := node.(type) {
+	case *DerivativeNode:
+		return true
+	case *UnaryNode:
+		return containsDerivative(n.Operand)
+	case *BinaryNode:
+		return containsDerivative(n.Left) || containsDerivative(n.Right)
+	case *CallNode:
+		for _, arg := range n.Args {
+			if containsDerivative(arg) {
+				return true
+			}
+		}
+	case *IntegralNode:
+		return containsDerivative(n.Body) || containsDerivative(n.Lower) || containsDerivative(n.Upper)
+	case *SeriesNode:
+		return containsDerivative(n.Body) || containsDerivative(n.From) || containsDerivative(n.To)
+	case *TupleNode:
+		for _, item := range n.Items {
+			if containsDerivative(item) {
+				return true
+			}
+		}
+	case *EquationNode:
+		return containsDerivative(n.Left) || containsDerivative(n.Right)
+	case *ComparisonNode:
+		return containsDerivative(n.Left) || containsDerivative(n.Right)
+	case *LogicalNode:
+		return containsDerivative(n.Left) || containsDerivative(n.Right)
+	case *PiecewiseNode:
+		for i, condition := range n.Conditions {
+			if containsDerivative(condition) || containsDerivative(n.Values[i]) {
+				return true
+			}
+		}
+		return n.Otherwise != nil && containsDerivative(n.Otherwise)
+	}
+	return false
+}
+
+// simplify applies algebraic identities and folds numbers so derivatives read the way they would be written by hand
+func simplify(node Node) Node {
+	switch n := node.(type) {
+	case *UnaryNode:
+		operand := simplify(n.Operand)
+		return negate(operand, n.Position)
+	case *BinaryNode:
+		return simplifyBinary(n.Op, simplify(n.Left), simplify(n.Right), n.Position)
+	case *CallNode:
+		args := make([]Node, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = simplify(arg)
+		}
+		return &CallNode{Name: n.Name, Args: args, Position: n.Position}
+	case *DerivativeNode:
+		if n.Result == nil {
+			return n
+		}
+		return &DerivativeNode{Operand: n.Operand, Result: simplify(n.Result), Position: n.Position}
+	case *SeriesNode:
+		return &SeriesNode{Op: n.Op, Index: n.Index, From: n.From, To: n.To, Body: simplify(n.Body), Position: n.Position}
+	case *PiecewiseNode:
+		simplified := &PiecewiseNode{Conditions: n.Conditions, Position: n.Position}
+		for _, value := range n.Values {
+			simplified.Values = append(simplified.Values, simplify(value))
+		}
+		if n.Otherwise != nil {
+			simplified.Otherwise = simplify(n.Otherwise)
+		}
+		return simplified
+	}
+	return node
+}
+
+func simplifyBinary(op string, l, r Node, pos int) Node {
+	lv, lNum := numberValue(l)
+	rv, rNum := numberValue(r)
+
+	if lNum && rNum {
+		switch op {
+		case "+":
+			return number(lv+rv, pos)
+		case "-":
+			return number(lv-rv, pos)
+		case "*":
+			return number(lv*rv, pos)
+		case "/":
+			// only fold exact quotients, 1/3 reads better than 0.3333333333333333
+			if rv != 0 && math.Mod(lv, rv) == 0 {
+				return number(lv/rv, pos)
+			}
+		case "^":
+			if result := math.Pow(lv, rv); rv >= 0 && result == math.Trunc(result) && !math.IsInf(result, 0) {
+				return number(result, pos)
+			}
+		}
+	}
+
+	// Pull negations outwards so they collapse or become subtractions
+	lNeg, lIsNeg := l.(*UnaryNode)
+	rNeg, rIsNeg := r.(*UnaryNode)
+
+	switch op {
+	case "+":
+		if lNum && lv == 0 {
+			return r
+		}
+		if rNum && rv == 0 {
+			return l
+		}
+		if rNum && rv < 0 {
+			return simplifyBinary("-", l, number(-rv, pos), pos)
+		}
+		if rIsNeg {
+			return simplifyBinary("-", l, rNeg.Operand, pos)
+		}
+	case "-":
+		if l.String() == r.String() {
+			return number(0, pos)
+		}
+		if rNum && rv == 0 {
+			return l
+		}
+		if lNum && lv == 0 {
+			return negate(r, pos)
+		}
+		if rNum && rv < 0 {
+			return simplifyBinary("+", l, number(-rv, pos), pos)
+		}
+		if rIsNeg {
+			return simplifyBinary("+", l, rNeg.Operand, pos)
+		}
+	case "*":
+		if (lNum && lv == 0) || (rNum && rv == 0) {
+			return number(0, pos)
+		}
+		if lNum && lv == 1 {
+			return r
+		}
+		if rNum && rv == 1 {
+			return l
+		}
+		if lNum && lv == -1 {
+			return negate(r, pos)
+		}
+		if rNum && rv == -1 {
+			return negate(l, pos)
+		}
+		if lIsNeg {
+			return negate(simplifyBinary("*", lNeg.Operand, r, pos), pos)
+		}
+		if rIsNeg {
+			return negate(simplifyBinary("*", l, rNeg.Operand, pos), pos)
+		}
+		// Coefficients go in front: u * 2 -> 2 * u, 2 * (3 * u) -> 6 * u
+		if rNum {
+			return simplifyBinary("*", r, l, pos)
+		}
+		if inner, ok := r.(*BinaryNode); ok && lNum && inner.Op == "*" {
+			if iv, ok := numberValue(inner.Left); ok {
+				return simplifyBinary("*", number(lv*iv, pos), inner.Right, pos)
+			}
+		}
+		// (1 / a) * b -> b / a, a * (1 / b) -> a / b
+		if frac, ok := l.(*BinaryNode); ok && frac.Op == "/" {
+			if one, ok := numberValue(frac.Left); ok && one == 1 {
+				return simplifyBinary("/", r, frac.Right, pos)
+			}
+		}
+		if frac, ok := r.(*BinaryNode); ok && frac.Op == "/" {
+			if one, ok := numberValue(frac.Left); ok && one == 1 {
+				return simplifyBinary("/", l, frac.Right, pos)
+			}
+		}
+	case "/":
+		if l.String() == r.String() {
+			return number(1, pos)
+		}
+		if lNum && lv == 0 {
+			return number(0, pos)
+		}
+		if rNum && rv == 1 {
+			return l
+		}
+		if lIsNeg {
+			return negate(simplifyBinary("/", lNeg.Operand, r, pos), pos)
+		}
+		if rIsNeg {
+			return negate(simplifyBinary("/", l, rNeg.Operand, pos), pos)
+		}
+	case "^":
+		if rNum && rv == 0 {
+			return number(1, pos)
+		}
+		if rNum && rv == 1 {
+			return l
+		}
+		if lNum && lv == 1 {
+			return number(1, pos)
+		}
+	}
+	return binary(op, l, r, pos)
+}
+
+func numberValue(node Node) (float64, bool) {
+	if n, ok := node.(*NumberNode); ok {
+		return n.Value, true
+	}
+	return 0, false
+}
+
+// Small constructors keeping the rule tables above readable
+
+func number(value float64, pos int) Node {
+	return &NumberNode{Value: value, Position: pos}
+}
+
+func binary(op string, l, r Node, pos int) Node {
+	return &BinaryNode{Op: op, Left: l, Right: r, Position: pos}
+}
+
+func call(name string, pos int, args ...Node) Node {
+	return &CallNode{Name: name, Args: args, Position: pos}
+}
+
+// Negates node, folding numbers and double negations
+func negate(node Node, pos int) Node {
+	switch n := node.(type) {
+	case *NumberNode:
+		return number(-n.Value, pos)
+	case *UnaryNode:
+		return n.Operand
+	}
+	return &UnaryNode{Op: "-", Operand: node, Position: pos}
+}
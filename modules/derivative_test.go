@@ -0,0 +1,132 @@
+package modules
+
+import (
+	"math"
+	"testing"
+)
+
+// Reports whether got is within tolerance of want, relative to want once it is larger than 1
+func closeTo(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= tolerance*math.Max(1, math.Abs(want))
+}
+
+func TestDifferentiate(t *testing.T) {
+	tests := []struct {
+		name, expr, want string
+		derivative       func(x float64) float64 // the derivative worked out by hand, checked at a few points
+	}{
+		{"constant", "5", "0", func(x float64) float64 { return 0 }},
+		{"power", "x^3", "3 * x^2", func(x float64) float64 { return 3 * x * x }},
+		{"product", "x^2 sin(x)", "2 * x * sin(x) + x^2 * cos(x)", func(x float64) float64 {
+			return 2*x*math.Sin(x) + x*x*math.Cos(x)
+		}},
+		{"quotient", "sin(x)/x", "(cos(x) * x - sin(x)) / x^2", func(x float64) float64 {
+			return (x*math.Cos(x) - math.Sin(x)) / (x * x)
+		}},
+		{"chain", "sin(x^2)", "cos(x^2) * 2 * x", func(x float64) float64 { return 2 * x * math.Cos(x*x) }},
+		{"nested chain", "ln(cos(x))", "-sin(x) / cos(x)", func(x float64) float64 { return -math.Tan(x) }},
+		{"exp", "exp(3x)", "3 * exp(3 * x)", func(x float64) float64 { return 3 * math.Exp(3*x) }},
+		{"variable exponent", "x^x", "x^x * (ln(x) + 1)", func(x float64) float64 { return math.Pow(x, x) * (math.Log(x) + 1) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := NewCalculator()
+			node, err := c.Differentiate(test.expr)
+			if err != nil {
+				t.Fatalf("Differentiate(%q): %v", test.expr, err)
+			}
+			if got := node.String(); got != test.want {
+				t.Errorf("Differentiate(%q) = %s, want %s", test.expr, got, test.want)
+			}
+			f := c.compile(node)
+			for _, x := range []float64{0.3, 1, 2.5} {
+				got, err := f(x)
+				if err != nil {
+					t.Fatalf("d/dx %s at %g: %v", test.expr, x, err)
+				}
+				if want := test.derivative(x); !closeTo(got, want, 1e-12) {
+					t.Errorf("d/dx %s at %g = %g, want %g", test.expr, x, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	tests := []struct{ expr, want string }{
+		{"0 + x", "x"},
+		{"x * 1", "x"},
+		{"0 * sin(x)", "0"},
+		{"x^1", "x"},
+		{"2 * 3 + x", "6 + x"},
+	}
+	c := NewCalculator()
+	for _, test := range tests {
+		node, err := c.Parse(test.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", test.expr, err)
+		}
+		if got := simplify(node).String(); got != test.want {
+			t.Errorf("simplify(%s) = %s, want %s", test.expr, got, test.want)
+		}
+	}
+}
+
+// min has no symbolic derivative, so d/dx(min(x, 1)) and the derivative of a function registered
+// as Go code are both approximated numerically
+func TestNumericalFallback(t *testing.T) {
+	c := NewCalculator()
+	if _, err := c.Differentiate("min(x, 1)"); err == nil {
+		t.Fatal("Differentiate(min(x, 1)) should have no symbolic result")
+	}
+	f, err := c.CreateFunction("d/dx(min(x, 1))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, want := range map[float64]float64{0.5: 1, 2: 0} {
+		if got, err := f(x); err != nil || !closeTo(got, want, 1e-8) {
+			t.Errorf("d/dx(min(x, 1)) at %g = %g, %v, want %g", x, got, err, want)
+		}
+	}
+
+	c.SetFunction("g", func(x float64) (float64, error) { return x * x * x, nil })
+	g, err := c.CreateFunction("g'(x)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := g(2); err != nil || !closeTo(got, 12, 1e-8) {
+		t.Errorf("g'(2) = %g, %v, want 12", got, err)
+	}
+	if _, err := NumericalDerivative(func(x float64) (float64, error) { return math.Sqrt(x), nil }, 2); err != nil {
+		t.Errorf("NumericalDerivative of sqrt at 2: %v", err)
+	}
+}
+
+// d/dx inside piecewise, sum and integral nodes is found, shown expanded and evaluated
+func TestNestedDerivatives(t *testing.T) {
+	tests := []struct {
+		expr, expanded string
+		x, want        float64
+	}{
+		{"{x < 0: d/dx(x^2), d/dx(sin(x))}", "{x < 0: 2 * x, cos(x)}", 2, math.Cos(2)},
+		{"{x < 0: d/dx(x^2), d/dx(sin(x))}", "{x < 0: 2 * x, cos(x)}", -1, -2},
+		{"sum(k, 1, 3, d/dx(x^k))", "sum(k, 1, 3, k * x^(k - 1))", 2, 1 + 2*2 + 3*4},
+		{"int(d/dx(x^3), x, 0, 1)", "int(3 * x^2, x, 0, 1)", 0, 1},
+	}
+	c := NewCalculator()
+	for _, test := range tests {
+		node, err := c.Parse(test.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", test.expr, err)
+		}
+		if !containsDerivative(node) {
+			t.Errorf("containsDerivative(%s) = false", test.expr)
+		}
+		if got := expandDerivatives(node).String(); got != test.expanded {
+			t.Errorf("expandDerivatives(%s) = %s, want %s", test.expr, got, test.expanded)
+		}
+		if got, err := c.compile(node)(test.x); err != nil || !closeTo(got, test.want, 1e-9) {
+			t.Errorf("%s at %g = %g, %v, want %g", test.expr, test.x, got, err, test.want)
+		}
+	}
+}
@@ -48,7 +48,28 @@ type expression struct {
 	err             error
 	color           tcell.Color
 	index           int
-	function        func(x float64) (float64, error)
+	function        func(x float64) (float64, error)          // nil when the row cannot be graphed against x
+	pieces          func(x float64) int                       // which pieces of the row's piecewise parts apply at x
+	params          []string                                  // nil unless the row names its parameters, x is then implied
+	node            Node                                      // parsed form of formationString, nil until it parses
+	bounds          []float64                                 // values of a trailing [min, max(, step)] range, nil without one
+	boundsText      string                                    // the range as typed, kept when a slider rewrites the value
+	slider          *slider                                   // shown in place of the response field for constant rows with a range
+	curve           func(t float64) (x, y float64, err error) // set instead of function for parametric (x(t), y(t)) rows
+	tRange          [2]float64                                // parameter interval a curve is sampled over
+	samples         int                                       // number of points sampled along a curve
+	adaptive        bool                                      // sample the curve by how sharply it bends instead of evenly
+	implicit        func(x, y float64) (float64, error)       // left minus right side of an equation row, drawn where it is zero
+	region          func(x, y float64) (bool, error)          // condition of an inequality row, shaded where it holds
+	area            func(x float64) (float64, error)          // integrand of a definite integral row, shaded over areaRange
+	integrand       func(x float64) (float64, error)          // set for rows integrating from lower up to x, plotted as a running sum
+	lower           float64                                   // where such a running integral starts
+	areaRange       [2]float64                                // bounds of the integral, in the order written
+	ode             *odeProblem                               // differential equation row, solved across tRange from its initial condition
+	table           *dataTable                                // points of a table row, drawn as markers
+	fit             *regression                               // regression row, its model is plotted as function with the fitted constants
+	fitted          []string                                  // constants a regression row registered, taken back before it is fitted again
+	lastFit         *regression                               // the row's last fit, kept so it isn't fitted again while nothing it uses changes
 	full            *tview.Flex
 	expressionField *tview.InputField
 	responseField   *tview.InputField
@@ -57,6 +78,10 @@ type expression struct {
 }
 
 var Expressions = []expression{}
+
+// calc holds every definition made through the expression rows
+var calc = NewCalculator()
+var preciseDigits = 0 // significant digits of the high precision values shown for constant rows, 0 shows plain float64 values
 var focusedExpressionIndex = 0
 var queInputUpdate = false
 var queGraphUpdate = false
@@ -177,7 +202,8 @@ func newExpression(index int) {
 			err:             nil,
 			color:           color,
 			index:           index,
-			function:        func(x float64) (float64, error) { return 0, nil },
+			function:        nil,
+			params:          nil,
 			full:            full,
 			expressionField: exprField,
 			responseField:   responseField,
@@ -218,15 +244,15 @@ func newExpression(index int) {
 		if curr == -1 {
 			return
 		}
-		// Support editing name inline via definition syntax: name = expression
+		// Support editing name inline via definition syntax: name = expression, or name(a, b) = expression
 		raw := strings.TrimSpace(text)
 		var defName string
 		var rhs string
-		if strings.Contains(raw, "=") {
-			parts := strings.SplitN(raw, "=", 2)
+		var params []string
+		if lhs, value, ok := splitDefinition(raw); ok {
 			// Allow exactly one optional space immediately before '='.
 			// Still disallow spaces within the identifier itself.
-			eqPos := strings.Index(text, "=")
+			eqPos := strings.Index(text, raw) + len(lhs)
 			leftSegment := text
 			if eqPos != -1 {
 				leftSegment = text[:eqPos]
@@ -251,8 +277,12 @@ func newExpression(index int) {
 				return
 			}
 
-			// Disallow spaces inside the identifier itself (excluding allowed trailing single space)
+			// Disallow spaces inside the identifier itself (excluding allowed trailing single space),
+			// the parameter list of a function definition may be spaced freely
 			coreLeft := strings.TrimRight(leftSegment, " ")
+			if open := strings.Index(coreLeft, "("); open != -1 {
+				coreLeft = coreLeft[:open]
+			}
 			if strings.Contains(coreLeft, " ") {
 				// Find the first internal space position
 				spacePos := strings.Index(coreLeft, " ")
@@ -263,13 +293,24 @@ func newExpression(index int) {
 				return
 			}
 
-			defName = strings.TrimSpace(strings.ToLower(parts[0]))
-			rhs = strings.TrimSpace(parts[1])
+			name, defParams, sigErr := parseSignature(strings.ToLower(lhs))
+			if sigErr != nil {
+				Expressions[curr].err = sigErr
+				Expressions[curr].responseText = sigErr.Error()
+				queResponseUpdate = true
+				return
+			}
+			defName = name
+			rhs = strings.TrimSpace(value)
 			if defName != "" && isValidIdentifier(defName) {
+				if defParams != nil {
+					params = defParams
+				}
 				// Update name and label dynamically
 				old := Expressions[curr].name
 				Expressions[curr].name = defName
 				if old != defName {
+					calc.Remove(strings.ToLower(old))
 					renameVariable(old, defName)
 				}
 			} else {
@@ -277,47 +318,14 @@ func newExpression(index int) {
 				rhs = raw
 			}
 		} else {
+			// Equations such as x^2 + y^2 = 9 are kept whole, the row keeps its name
 			rhs = raw
 		}
 
 		Expressions[curr].formationString = rhs
-		Expressions[curr].function, Expressions[curr].err = CreateFunction(rhs)
-		if Expressions[curr].err == nil {
-			// If expression is constant (no standalone variable 'x'), show its value as "= [X]"
-			if toks, tokErr := tokenize(rhs); tokErr == nil {
-				isConst := true
-				for _, t := range toks {
-					if t.Type == VARIABLE {
-						isConst = false
-						break
-					}
-				}
-				if isConst {
-					// Evaluate once to display the constant value
-					if pf, pfErr := toPostfix(toks); pfErr == nil {
-						if val, evErr := evaluatePostfix(pf, toks, 0); evErr == nil {
-							Expressions[curr].responseText = fmt.Sprintf("= %g", val)
-						} else {
-							Expressions[curr].responseText = ""
-						}
-					} else {
-						Expressions[curr].responseText = ""
-					}
-				} else {
-					Expressions[curr].responseText = ""
-				}
-			} else {
-				Expressions[curr].responseText = ""
-			}
-			// Register this expression under its name for cross-reference
-			nameKey := strings.ToLower(Expressions[curr].name)
-			userFunctions[nameKey] = Expressions[curr].function
-			queGraphUpdate = true
-		} else {
-			Expressions[curr].responseText = Expressions[curr].err.Error()
-			// If invalid, remove from user functions to avoid stale references
-			delete(userFunctions, strings.ToLower(Expressions[curr].name))
-		}
+		Expressions[curr].params = params
+		evaluateRow(curr)
+		refreshRows(curr)
 		queResponseUpdate = true
 	}).SetDoneFunc(func(key tcell.Key) {
 		switch key {
@@ -348,6 +356,15 @@ func newExpression(index int) {
 
 	// Capture Backspace when the input is empty to remove the expression row
 	Expressions[index].expressionField.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyCtrlS {
+			for i := range Expressions {
+				if Expressions[i].expressionField == exprField {
+					toggleSlider(i)
+					break
+				}
+			}
+			return nil
+		}
 		if event.Key() == tcell.KeyBackspace || event.Key() == tcell.KeyBackspace2 {
 			curr := -1
 			for i := range Expressions {
@@ -374,805 +391,625 @@ func newExpression(index int) {
 	queResponseUpdate = true
 }
 
-func nextUnusedYName() string {
-	max := 0
-	for _, e := range Expressions {
-		name := strings.ToLower(strings.TrimSpace(e.name))
-		if len(name) >= 2 && name[0] == 'y' {
-			numStr := name[1:]
-			if n, err := strconv.Atoi(numStr); err == nil {
-				if n > max {
-					max = n
+// Parses and registers a row from its formationString and params, updating its response text and slider
+func evaluateRow(i int) {
+	expr := &Expressions[i]
+	nameKey := strings.ToLower(expr.name)
+	calc.Remove(nameKey) // a row may not refer to itself
+	for _, name := range expr.fitted {
+		if !definedByOtherRow(expr, name) {
+			calc.Remove(name)
+		}
+	}
+	expr.fitted = nil
+	expr.node, expr.function, expr.curve, expr.implicit, expr.region, expr.area, expr.integrand, expr.err = nil, nil, nil, nil, nil, nil, nil, nil
+	expr.ode, expr.table, expr.fit = nil, nil, nil
+	expr.adaptive = false
+	expr.responseText = ""
+	if strings.TrimSpace(expr.formationString) == "" {
+		setSlider(i, false)
+		return
+	}
+
+	body, bounds, boundsText, err := splitBounds(expr.formationString)
+	expr.bounds, expr.boundsText = bounds, boundsText
+	params := expr.params
+	extra := "" // variable only some kinds of row may use, dropped again for ordinary rows
+	if params == nil {
+		params = []string{"x"}
+		switch {
+		case strings.ContainsAny(body, "=<>"):
+			extra = "y" // equations and inequalities are in x and y
+		case isTuple(body):
+			params = []string{"t"} // parametric curves are written in t unless the row names its parameter
+		case nameKey == "r":
+			extra = "theta" // r = f(theta) is a polar curve, r = f(x) still an ordinary function
+		}
+		if extra != "" {
+			params = append(params, extra)
+		}
+	}
+	switch {
+	case err != nil:
+	case isTable(body):
+		err = setTable(expr, body)
+	case isRegression(body):
+		err = setRegression(expr, body)
+	case isODE(body):
+		err = setODE(expr, body)
+	default:
+		expr.node, err = calc.parseWith(body, params)
+	}
+	var unit dimension // values with units are worked out in SI base units, unit says which
+	if err == nil {
+		unit, err = calc.dimensionOf(expr.node, nil)
+	}
+
+	isConstant := false
+	var value complex128
+	if err == nil && expr.node != nil {
+		switch node := expr.node.(type) {
+		case *EquationNode:
+			err = setImplicit(expr, node)
+		case *TupleNode:
+			err = setCurve(expr, node, params)
+		case *ComparisonNode, *LogicalNode:
+			err = setRegion(expr, node)
+		default:
+			if extra != "" {
+				params = params[:1]
+				if usesVariable(node, extra) && extra == "theta" {
+					err = setPolar(expr)
+					break
+				}
+				if usesVariable(node, extra) {
+					// y only has a meaning in equations and inequalities, parsing without it points at where it was used
+					_, err = calc.parseWith(body, params)
+					break
+				}
+			}
+			expr.function, expr.pieces = rowFunction(params, node), rowPieces(params, node)
+			if integral, ok := node.(*IntegralNode); ok && len(params) == 1 {
+				err = setAntiderivative(expr, integral, params[0])
+			}
+			// If expression is constant (no standalone variable 'x'), show its value as "= [X]"
+			// and for derivatives show the symbolic result
+			if containsDerivative(node) {
+				expr.responseText = "= " + expandDerivatives(node).String()
+			}
+			if len(params) == 1 && !usesVariable(node, params[0]) {
+				if value, err = rowConstant(params[0], node); err == nil {
+					expr.responseText = "= " + withUnit(formatComplex(value), unit)
+					isConstant = expr.params == nil
+				}
+				// The float64 value above is still what everything else uses, a precise one is only shown
+				if err == nil && preciseDigits > 0 && !calc.IsComplex() {
+					if precise, err := calc.preciseResult(node, preciseDigits); err == nil {
+						expr.responseText = "= " + withUnit(precise.format(preciseDigits), unit)
+					}
+				}
+				if integral, ok := node.(*IntegralNode); ok && err == nil {
+					err = setArea(expr, integral, params[0])
 				}
 			}
 		}
 	}
-	return "y" + strconv.Itoa(max+1)
-}
+	if err == nil && bounds != nil && expr.curve == nil && expr.ode == nil && expr.table == nil && expr.fit == nil {
+		switch {
+		case !isConstant:
+			err = errors.New("a [min, max, step] range turns constant rows into sliders, this row is not constant")
+		case len(bounds) != 3:
+			err = &ExpressionError{"a slider range needs a step: [min, max, step]", boundsPosition(expr)}
+		case bounds[2] <= 0:
+			err = &ExpressionError{"range step must be positive", boundsPosition(expr)}
+		case imag(value) != 0:
+			err = &ExpressionError{"a complex constant cannot be a slider", boundsPosition(expr)}
+		case !unit.none():
+			err = &ExpressionError{"a constant with units cannot be a slider", boundsPosition(expr)}
+		}
+	}
 
-func removeExpression(index int) {
-	// Shift names upward to keep yN ordering stable
-	for i := len(Expressions) - 1; i > index; i-- {
-		renameVariable(Expressions[i].name, Expressions[i-1].name)
-		// add something to replace all instances of expression at index with the text inside of that expression
+	expr.err = err
+	if err != nil {
+		expr.function, expr.curve, expr.implicit, expr.region, expr.area, expr.ode, expr.table, expr.fit = nil, nil, nil, nil, nil, nil, nil, nil
+		expr.responseText = err.Error()
+		setSlider(i, false)
+		return
 	}
 
-	Expressions = append(
-		Expressions[:index],
-		Expressions[index+1:]...)
-	updateExpressionBox()
-}
+	// Tables are referred to by their columns, t1.y, rather than as constants or functions
+	if expr.table != nil {
+		calc.SetTable(nameKey, expr.table.xs, expr.table.ys)
+		setSlider(i, false)
+		queGraphUpdate = true
+		return
+	}
 
-func renameVariable(originalName string, newName string) {
-	// Replace occurrences of the originalName with newName in all expressions
-	// and re-create their functions to keep everything consistent.
-	for i := range Expressions {
-		// Skip the expression that's currently being renamed to avoid recursive change callbacks
-		if i == focusedExpressionIndex {
-			continue
+	// A regression's fitted constants can be used by other rows, its model is only drawn
+	if expr.fit != nil {
+		for i, name := range expr.fit.params {
+			calc.SetConstant(name, expr.fit.values[i])
 		}
+		expr.fitted = expr.fit.params
+	}
 
-		// Update the visible input text for other expressions
-		fieldText := Expressions[i].expressionField.GetText()
-		fieldUpdated := strings.ReplaceAll(fieldText, originalName, newName)
-		if fieldUpdated != fieldText {
-			Expressions[i].expressionField.SetText(fieldUpdated)
-		}
+	// Curves, equations, regions, differential equations and regressions are drawn but can't be referred to by other rows
+	if expr.curve != nil || expr.implicit != nil || expr.region != nil || expr.ode != nil || expr.fit != nil {
+		setSlider(i, false)
+		queGraphUpdate = true
+		return
+	}
 
-		// Update the stored RHS expression
-		updated := strings.ReplaceAll(Expressions[i].formationString, originalName, newName)
-		if updated != Expressions[i].formationString {
-			Expressions[i].formationString = updated
-			Expressions[i].function, Expressions[i].err = CreateFunction(updated)
-			if Expressions[i].err == nil {
-				Expressions[i].responseText = ""
-				userFunctions[strings.ToLower(Expressions[i].name)] = Expressions[i].function
-			} else {
-				Expressions[i].responseText = Expressions[i].err.Error()
-			}
-		}
+	// Register this expression under its name for cross-reference, constants are looked up
+	// on every evaluation so rows using them follow along when they change
+	if isConstant {
+		calc.SetComplexConstant(nameKey, value)
+		calc.setConstantNode(nameKey, expr.node)
+		calc.setConstantUnits(nameKey, unit)
+	} else {
+		calc.defineFunction(nameKey, params, expr.node)
+	}
+	setSlider(i, bounds != nil)
+	if expr.slider != nil {
+		expr.slider.setRange(bounds[0], bounds[1], bounds[2])
+		expr.slider.value = real(value)
 	}
-	queResponseUpdate = true
 	queGraphUpdate = true
 }
 
-type ExpressionError struct {
-	Message  string
-	Position int
-}
-
-func (e *ExpressionError) Error() string {
-	return fmt.Sprintf("%s at position %d", e.Message, e.Position)
-}
-
-type TokenType int
+const defaultCurveSamples = 1000
 
-const (
-	NUMBER TokenType = iota
-	OPERATOR
-	FUNCTION
-	LPAREN
-	RPAREN
-	VARIABLE
-	CONSTANT
-)
-
-type Token struct {
-	Type     TokenType
-	Value    string
-	Position int
-}
-
-// Operator precedence
-var precedence = map[string]int{
-	"+":  1,
-	"-":  1,
-	"*":  2,
-	"/":  2,
-	"^":  3,
-	"u-": 2, // unary minus has higher precedence than +,- and same as */
-}
-
-// Mathematical constants
-var mathConstants = map[string]float64{
-	"e":   math.E,
-	"pi":  math.Pi,
-	"phi": math.Phi,
-	"tau": 2 * math.Pi,
-}
-
-// Built in mathematical functions
-var mathFuncs = map[string]func(float64) float64{
-	"sin":  math.Sin,
-	"cos":  math.Cos,
-	"tan":  math.Tan,
-	"sqrt": math.Sqrt,
-	"ln":   math.Log,
-	"exp":  math.Exp,
-	"abs":  math.Abs,
-	"asin": math.Asin,
-	"acos": math.Acos,
-	"atan": math.Atan,
-	"d/dx": nil, // d/dx is handled as a special case, but still needs to be recognized as a "function"
-}
-
-func validateExpression(expr string) error {
-	if len(strings.TrimSpace(expr)) == 0 {
-		return errors.New("empty expression")
+// Sets up a parametric row, sampled over its trailing [tmin, tmax] or [tmin, tmax, samples]
+// range, or from 0 to 2π when it has none
+func setCurve(expr *expression, tuple *TupleNode, params []string) error {
+	if len(tuple.Items) != 2 {
+		return &ExpressionError{"a parametric curve needs two coordinates: (x(t), y(t))", tuple.Position}
 	}
-
-	// Check for unclosed parentheses
-	parenCount := 0
-	for i, char := range expr {
-		if char == '(' {
-			parenCount++
-		} else if char == ')' {
-			parenCount--
-			if parenCount < 0 {
-				return &ExpressionError{"unmatched closing parenthesis", i}
+	if len(params) != 1 {
+		return &ExpressionError{"a parametric curve takes a single parameter", tuple.Position}
+	}
+	expr.tRange, expr.samples = [2]float64{0, 2 * math.Pi}, defaultCurveSamples
+	if bounds := expr.bounds; bounds != nil {
+		expr.tRange = [2]float64{bounds[0], bounds[1]}
+		if len(bounds) == 3 {
+			if bounds[2] < 2 || bounds[2] != math.Trunc(bounds[2]) {
+				return &ExpressionError{"sample count must be a whole number of at least 2", boundsPosition(expr)}
 			}
+			expr.samples = int(bounds[2])
 		}
 	}
-	if parenCount > 0 {
-		return &ExpressionError{"unclosed parenthesis", len(expr) - 1}
-	}
 
-	// Check for consecutive operators
-	prevIsOp := true // treat start of expression as if it follows an operator
-	for i, char := range expr {
-		isOp := strings.ContainsRune("+-*/^", char)
-		if isOp && prevIsOp && char != '-' { // TODO: allow negative numbers
-			return &ExpressionError{"consecutive operators", i}
+	param := params[0]
+	xNode, yNode := tuple.Items[0], tuple.Items[1]
+	expr.curve = func(t float64) (float64, float64, error) {
+		vars := map[string]float64{param: t}
+		x, err := calc.evaluate(xNode, vars)
+		if err != nil {
+			return 0, 0, err
 		}
-		prevIsOp = isOp
-	}
-
-	// Check for trailing operator
-	lastChar := expr[len(expr)-1]
-	if strings.ContainsRune("+-*/^", rune(lastChar)) {
-		return &ExpressionError{"trailing operator", len(expr) - 1}
+		y, err := calc.evaluate(yNode, vars)
+		if err != nil {
+			return 0, 0, err
+		}
+		return x, y, nil
 	}
-
+	expr.responseText = fmt.Sprintf("%s from %.4g to %.4g, %d samples", param, expr.tRange[0], expr.tRange[1], expr.samples)
 	return nil
 }
 
-// Converts a string expression into tokens
-func tokenize(expr string) ([]Token, error) {
-	// Check if it's a definition
-	if strings.Contains(expr, "=") {
-		return nil, errors.New("definitions must be processed separately")
+// Sets up an equation row such as x^2 + y^2 = 9, drawn wherever both sides agree
+func setImplicit(expr *expression, equation *EquationNode) error {
+	if expr.bounds != nil {
+		return &ExpressionError{"an equation takes no range", boundsPosition(expr)}
 	}
-
-	if err := validateExpression(expr); err != nil {
-		return nil, err
+	if expr.params != nil {
+		return &ExpressionError{"an equation is drawn in x and y and takes no parameters", equation.Position}
 	}
-
-	var tokens []Token
-	i := 0
-
-	// Skip leading whitespace
-	expr = strings.TrimSpace(expr)
-
-	for i < len(expr) {
-		char := string(expr[i])
-		curPos := i
-
-		// Skip whitespace
-		if char == " " {
-			i++
-			continue
-		}
-
-		// Handle numbers
-		if (char >= "0" && char <= "9") || char == "." {
-			num := ""
-			dotCount := 0
-			startPos := i
-
-			// Read the entire number
-			for i < len(expr) && ((expr[i] >= '0' && expr[i] <= '9') || expr[i] == '.') {
-				if expr[i] == '.' {
-					dotCount++
-					if dotCount > 1 {
-						return nil, &ExpressionError{"multiple decimal points in number", i}
-					}
-				}
-				num += string(expr[i])
-				i++
-			}
-
-			if num == "." {
-				return nil, &ExpressionError{"invalid number format", curPos}
-			}
-
-			tokens = append(tokens, Token{Type: NUMBER, Value: num, Position: startPos})
-
-			// Skip any whitespace after the number
-			for i < len(expr) && expr[i] == ' ' {
-				i++
-			}
-
-			// Check if there's another number after whitespace
-			if i < len(expr) {
-				nextChar := expr[i]
-				if (nextChar >= '0' && nextChar <= '9') || nextChar == '.' {
-					return nil, &ExpressionError{"missing operator between numbers", i}
-				}
-			}
-			continue
-		}
-
-		// Handle operators
-		if char == "+" || char == "-" || char == "*" || char == "/" || char == "^" {
-			// Determine if '-' is unary based on previous token
-			if char == "-" {
-				prevIsStart := len(tokens) == 0
-				prev := Token{Type: OPERATOR}
-				if !prevIsStart {
-					prev = tokens[len(tokens)-1]
-				}
-				if prevIsStart || prev.Type == OPERATOR || prev.Type == LPAREN {
-					// Unary minus
-					tokens = append(tokens, Token{Type: OPERATOR, Value: "u-", Position: curPos})
-					i++
-					continue
-				}
-			}
-			tokens = append(tokens, Token{Type: OPERATOR, Value: char, Position: curPos})
-			i++
-			continue
-		}
-
-		// Handle parentheses
-		if char == "(" {
-			tokens = append(tokens, Token{Type: LPAREN, Value: char, Position: curPos})
-			i++
-			continue
-		}
-		if char == ")" {
-			tokens = append(tokens, Token{Type: RPAREN, Value: char, Position: curPos})
-			i++
-			continue
-		}
-
-		// Handle variables
-		if char == "x" {
-			tokens = append(tokens, Token{Type: VARIABLE, Value: char, Position: curPos})
-			i++
-			continue
+	if !usesVariable(equation, "x") && !usesVariable(equation, "y") {
+		return &ExpressionError{"an equation needs x or y on one of its sides", equation.Position}
+	}
+	expr.implicit = func(x, y float64) (float64, error) {
+		vars := map[string]float64{"x": x, "y": y}
+		left, err := calc.evaluate(equation.Left, vars)
+		if err != nil {
+			return 0, err
 		}
-
-		// Handle constants and functions
-		if char >= "a" && char <= "z" {
-			name := ""
-			startPos := i
-			// Special case for d/dx
-			if i+3 < len(expr) && expr[i:i+4] == "d/dx" {
-				name = "d/dx"
-				i += 4
-			} else {
-				// Regular identifier handling
-				for i < len(expr) && ((expr[i] >= 'a' && expr[i] <= 'z') || (expr[i] >= '0' && expr[i] <= '9')) {
-					name += string(expr[i])
-					i++
-				}
-			}
-
-			// Check if it's a constant (built in or user defined)
-			if _, exists := mathConstants[name]; exists {
-				tokens = append(tokens, Token{Type: CONSTANT, Value: name, Position: startPos})
-				continue
-			}
-			if _, exists := userConstants[name]; exists {
-				tokens = append(tokens, Token{Type: CONSTANT, Value: name, Position: startPos})
-				continue
-			}
-
-			// Check if it's a function (built in or user defined)
-			if _, exists := mathFuncs[name]; exists {
-				tokens = append(tokens, Token{Type: FUNCTION, Value: name, Position: startPos})
-				continue
-			}
-			if _, exists := userFunctions[name]; exists {
-				tokens = append(tokens, Token{Type: FUNCTION, Value: name, Position: startPos})
-				continue
-			}
-
-			return nil, &ExpressionError{fmt.Sprintf("unknown identifier: %s", name), startPos}
+		right, err := calc.evaluate(equation.Right, vars)
+		if err != nil {
+			return 0, err
 		}
-
-		return nil, &ExpressionError{"invalid character", i}
+		return left - right, nil
 	}
-
-	return tokens, nil
+	expr.responseText = "implicit curve"
+	return nil
 }
 
-// handles user defined constants and functions
-func processDefinition(expr string) error {
-	// Special case for derivative expressions
-	if strings.HasPrefix(strings.TrimSpace(expr), "f = d/dx") {
-		// Extract the expression to be differentiated
-		parts := strings.SplitN(expr, "d/dx", 2)
-		if len(parts) != 2 {
-			return errors.New("invalid derivative expression")
-		}
+// Sets up an inequality row such as y < sin(x) or x^2 + y^2 <= 4, shaded wherever the condition holds
+func setRegion(expr *expression, condition Node) error {
+	if expr.bounds != nil {
+		return &ExpressionError{"an inequality takes no range", boundsPosition(expr)}
+	}
+	if expr.params != nil {
+		return &ExpressionError{"an inequality is drawn in x and y and takes no parameters", condition.Pos()}
+	}
+	expr.region = func(x, y float64) (bool, error) {
+		return calc.evalCondition(condition, map[string]float64{"x": x, "y": y})
+	}
+	expr.responseText = "shaded region"
+	return nil
+}
 
-		// Get the expression inside parentheses
-		exprToDerive := strings.TrimSpace(parts[1])
-		if !strings.HasPrefix(exprToDerive, "(") || !strings.HasSuffix(exprToDerive, ")") {
-			return errors.New("derivative expression must be enclosed in parentheses")
+// Sets up a polar row r = f(theta) as a curve traced over its trailing [theta min, theta max] range,
+// or a full turn when it has none, sampled more densely where it bends sharply
+func setPolar(expr *expression) error {
+	expr.tRange = [2]float64{0, 2 * math.Pi}
+	if bounds := expr.bounds; bounds != nil {
+		if len(bounds) != 2 {
+			return &ExpressionError{"a polar range is [theta min, theta max]", boundsPosition(expr)}
 		}
+		expr.tRange = [2]float64{bounds[0], bounds[1]}
+	}
 
-		// Remove the parentheses
-		exprToDerive = exprToDerive[1 : len(exprToDerive)-1]
-
-		// Calculate the derivative
-		f, err := Derivative(exprToDerive)
+	node := expr.node
+	expr.curve = func(theta float64) (float64, float64, error) {
+		r, err := calc.evaluate(node, map[string]float64{"theta": theta})
 		if err != nil {
-			return fmt.Errorf("error calculating derivative: %v", err)
+			return 0, 0, err
 		}
-
-		userFunctions["f"] = f
-		return nil
+		return r * math.Cos(theta), r * math.Sin(theta), nil
 	}
+	expr.adaptive = true
+	expr.responseText = fmt.Sprintf("theta from %.4g to %.4g", expr.tRange[0], expr.tRange[1])
+	return nil
+}
 
-	// Original definition processing
-	parts := strings.Split(expr, "=")
-	if len(parts) != 2 {
-		return errors.New("invalid definition format")
+// Sets up a table row of points typed in, table((1, 2), (2, 4)), or read from a CSV file, csv(data.csv)
+func setTable(expr *expression, body string) error {
+	if expr.bounds != nil {
+		return &ExpressionError{"a table takes no range", boundsPosition(expr)}
 	}
-
-	name := strings.TrimSpace(parts[0])
-	// Disallow spaces in identifier and provide a clear error with position
-	if strings.Contains(name, " ") {
-		spacePos := strings.Index(expr, " ")
-		if spacePos == -1 {
-			spacePos = 0
-		}
-		return &ExpressionError{"identifier cannot contain spaces", spacePos}
+	if expr.params != nil {
+		return &ExpressionError{"a table takes no parameters", 0}
+	}
+	xs, ys, source, err := calc.parseTable(body, 0)
+	if err != nil {
+		return err
 	}
-	value := strings.TrimSpace(parts[1])
+	expr.table = &dataTable{xs, ys}
+	expr.responseText = fmt.Sprintf("%d points", len(xs))
+	if len(xs) == 1 {
+		expr.responseText = "1 point"
+	}
+	if source != "" {
+		expr.responseText += " from " + source
+	}
+	return nil
+}
 
-	// Validate the name
-	if len(name) == 0 {
-		return errors.New("empty name in definition")
+// Sets up a regression row such as t1.y ~ a*x + b, fitted to the table and plotted with the fitted constants
+func setRegression(expr *expression, body string) error {
+	if expr.bounds != nil {
+		return &ExpressionError{"a regression takes no range", boundsPosition(expr)}
 	}
-	if !isValidIdentifier(name) {
-		return fmt.Errorf("invalid identifier name: %s", name)
+	if expr.params != nil {
+		return &ExpressionError{"a regression is in x and takes no parameters", 0}
 	}
-	if name == "x" {
-		return errors.New("cannot redefine variable 'x'")
+	fit, err := calc.parseRegression(body, expr.lastFit)
+	if err != nil {
+		return err
 	}
-	if _, exists := mathFuncs[name]; exists {
-		return fmt.Errorf("cannot redefine built-in function: %s", name)
+	expr.lastFit = fit
+	// A row defining one of the constants may come after this one and not be defined yet
+	for _, name := range fit.params {
+		if definedByOtherRow(expr, name) {
+			return &ExpressionError{fmt.Sprintf("%s is defined by another row, a fitted constant needs a name of its own", name), strings.Index(body, "~") + 1}
+		}
 	}
-	if _, exists := mathConstants[name]; exists {
-		return fmt.Errorf("cannot redefine built-in constant: %s", name)
+	parts := make([]string, len(fit.params))
+	for i, name := range fit.params {
+		parts[i] = fmt.Sprintf("%s = %.6g", name, fit.values[i])
 	}
-	if _, exists := userFunctions[name]; exists {
-		return fmt.Errorf("function %s is already defined", name)
+	if !math.IsNaN(fit.rSquared) {
+		parts = append(parts, fmt.Sprintf("R² = %.6f", fit.rSquared))
 	}
-	if _, exists := userConstants[name]; exists {
-		return fmt.Errorf("constant %s is already defined", name)
+	expr.fit, expr.responseText = fit, strings.Join(parts, ", ")
+	expr.function = func(x float64) (float64, error) {
+		return calc.predict(fit.model, fit.params, fit.values, x)
 	}
+	return nil
+}
 
-	// Check if it's a function definition
-	isFunction := false
-	tokens, err := tokenize(value)
-	if err != nil {
-		return fmt.Errorf("invalid expression in definition: %v", err)
-	}
-	for _, token := range tokens {
-		if token.Type == VARIABLE {
-			isFunction = true
-			break
+// Reports whether a row other than expr is named name, whether or not its definition is valid
+func definedByOtherRow(expr *expression, name string) bool {
+	for i := range Expressions {
+		other := &Expressions[i]
+		if other != expr && strings.ToLower(other.name) == name && strings.TrimSpace(other.formationString) != "" {
+			return true
 		}
 	}
+	return false
+}
 
-	if isFunction {
-		f, err := CreateFunction(value)
-		if err != nil {
-			return fmt.Errorf("invalid function definition: %v", err)
-		}
-		userFunctions[name] = f
-	} else {
-		f, err := CreateFunction(value)
-		if err != nil {
-			return fmt.Errorf("invalid constant definition: %v", err)
+// Sets up a differential equation row such as y' = x - y, y(0) = 1, solved across the view or
+// across its trailing [x min, x max] range when it has one
+func setODE(expr *expression, body string) error {
+	if expr.params != nil {
+		return &ExpressionError{"a differential equation is in x and y and takes no parameters", 0}
+	}
+	expr.tRange = [2]float64{math.Inf(-1), math.Inf(1)}
+	if bounds := expr.bounds; bounds != nil {
+		if len(bounds) != 2 || bounds[0] >= bounds[1] {
+			return &ExpressionError{"a differential equation range is [x min, x max]", boundsPosition(expr)}
 		}
-		userConstants[name], _ = f(0)
+		expr.tRange = [2]float64{bounds[0], bounds[1]}
+	}
+	problem, err := calc.parseODE(body)
+	if err != nil {
+		return err
 	}
+	expr.ode = problem
+	expr.responseText = fmt.Sprintf("solution through (%.4g, %.4g)", problem.x0, problem.initial[0])
 	return nil
 }
 
-func isValidIdentifier(name string) bool {
-	if len(name) == 0 {
-		return false
+// Adds the error estimate of a definite integral row to its response and shades the area it measures
+func setArea(expr *expression, integral *IntegralNode, param string) error {
+	vars := map[string]float64{param: 0}
+	_, estimate, err := calc.evalIntegral(integral, vars)
+	if err != nil {
+		return err
 	}
-	// First character must be a letter
-	if name[0] < 'a' || name[0] > 'z' {
-		return false
+	expr.responseText += " ± " + strconv.FormatFloat(estimate, 'g', 2, 64)
+	a, _ := calc.eval(integral.Lower, vars)
+	b, _ := calc.eval(integral.Upper, vars)
+	expr.area, expr.areaRange = calc.integrand(integral, vars), [2]float64{a, b}
+	return nil
+}
+
+// Lets a row that integrates from a fixed point up to its variable, F = integrate(y1, 0), be plotted
+// by a running sum across the samples instead of integrating from the start for every one of them
+func setAntiderivative(expr *expression, integral *IntegralNode, param string) error {
+	upper, ok := integral.Upper.(*VariableNode)
+	if !ok || upper.Name != param || usesVariable(integral.Lower, param) {
+		return nil
 	}
-	// Rest can be letters or numbers
-	for i := 1; i < len(name); i++ {
-		if !((name[i] >= 'a' && name[i] <= 'z') || (name[i] >= '0' && name[i] <= '9')) {
-			return false
-		}
+	if integral.Variable != param && usesVariable(integral.Body, param) {
+		return nil
+	}
+	lower, err := calc.eval(integral.Lower, nil)
+	if err != nil {
+		return err
 	}
-	return true
+	expr.integrand, expr.lower = calc.integrand(integral, nil), lower
+	return nil
 }
 
-// Convert infix notation to postfix
-func toPostfix(tokens []Token) ([]Token, error) {
-	var output []Token
-	var stack []Token
-
-	for _, token := range tokens {
-		switch token.Type {
-		case NUMBER, VARIABLE, CONSTANT:
-			output = append(output, token)
-
-		case FUNCTION:
-			stack = append(stack, token)
-
-		case OPERATOR:
-			for len(stack) > 0 {
-				top := stack[len(stack)-1]
-				if top.Type == OPERATOR && precedence[top.Value] >= precedence[token.Value] {
-					output = append(output, stack[len(stack)-1])
-					stack = stack[:len(stack)-1]
-				} else {
-					break
-				}
-			}
-			stack = append(stack, token)
-
-		case LPAREN:
-			stack = append(stack, token)
-
-		case RPAREN:
-			foundMatching := false
-			for len(stack) > 0 {
-				top := stack[len(stack)-1]
-				stack = stack[:len(stack)-1]
-				if top.Type == LPAREN {
-					foundMatching = true
-					if len(stack) > 0 && stack[len(stack)-1].Type == FUNCTION {
-						output = append(output, stack[len(stack)-1])
-						stack = stack[:len(stack)-1]
-					}
-					break
-				}
-				output = append(output, top)
-			}
-			if !foundMatching {
-				return nil, &ExpressionError{"unmatched closing parenthesis", token.Position}
-			}
-		}
+// Reports whether the text left of a row's first '=' names the row, as in "a = 2" or "g(t, k) = ...",
+// rather than being one side of an equation to draw, as in "x^2 + y^2 = 9" or "x = 3"
+func namesRow(lhs string) bool {
+	core := strings.ToLower(strings.TrimSpace(lhs))
+	if core == "" || core == "x" || core[0] < 'a' || core[0] > 'z' {
+		return false
+	}
+	if strings.Contains(core, "(") {
+		name, _, err := parseSignature(core)
+		_, builtin := mathFuncs[name]
+		return err == nil && !builtin && name != "x"
 	}
+	// Spaces are allowed through so a spaced name gets its own error
+	return strings.Trim(core, "abcdefghijklmnopqrstuvwxyz0123456789 ") == ""
+}
 
-	for len(stack) > 0 {
-		top := stack[len(stack)-1]
-		stack = stack[:len(stack)-1]
-		if top.Type == LPAREN {
-			return nil, &ExpressionError{"unclosed parenthesis", top.Position}
+// Reports whether text is a single parenthesized group with a top level comma, e.g. (cos(t), sin(t))
+func isTuple(text string) bool {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "(") {
+		return false
+	}
+	depth, comma := 0, false
+	for i, r := range text {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return comma && i == len(text)-1
+			}
+		case ',':
+			comma = comma || depth == 1
 		}
-		output = append(output, top)
 	}
-
-	return output, nil
+	return false
 }
 
-var userFunctions = make(map[string]func(float64) (float64, error))
-var userConstants = make(map[string]float64)
+// Position of a row's trailing range within its formationString
+func boundsPosition(expr *expression) int {
+	return max(0, strings.LastIndex(expr.formationString, expr.boundsText))
+}
 
-func CreateFunction(expr string) (func(float64) (float64, error), error) {
-	// Check if it's a definition
-	if strings.Contains(expr, "=") {
-		if err := processDefinition(expr); err != nil {
-			return nil, err
+// Re-evaluates every row but skip, so rows referring to one that changed pick up its new definition
+func refreshRows(skip int) {
+	for i := range Expressions {
+		if i != skip {
+			evaluateRow(i)
 		}
-		return nil, nil
 	}
+}
 
-	tokens, err := tokenize(expr)
-	if err != nil {
-		return nil, err
+// Splits a trailing "[min, max]" or "[min, max, step]" range off a row, each part may be any constant expression,
+// the third value is a slider's step or a curve's sample count
+func splitBounds(rhs string) (string, []float64, string, error) {
+	trimmed := strings.TrimRight(rhs, " ")
+	if !strings.HasSuffix(trimmed, "]") {
+		return rhs, nil, "", nil
 	}
-
-	// Add token sequence validation
-	if err := validateTokenSequence(tokens); err != nil {
-		return nil, err
+	open := strings.LastIndex(trimmed, "[")
+	if open == -1 {
+		return rhs, nil, "", &ExpressionError{"unmatched ']'", len(trimmed) - 1}
 	}
 
-	postfix, err := toPostfix(tokens)
-	if err != nil {
-		return nil, err
-	}
-	// If the expression has no standalone variable 'x', evaluate once to a constant
-	isConstant := true
-	for _, t := range tokens {
-		if t.Type == VARIABLE {
-			isConstant = false
-			break
-		}
+	parts := splitTopLevel(trimmed[open+1 : len(trimmed)-1])
+	if len(parts) != 2 && len(parts) != 3 {
+		return rhs, nil, "", &ExpressionError{"range must be [min, max] or [min, max, step]", open}
 	}
-
-	if isConstant {
-		val, err := evaluatePostfix(postfix, tokens, 0)
+	bounds := make([]float64, len(parts))
+	for i, part := range parts {
+		offset := open + 1 + part.offset + len(part.text) - len(strings.TrimLeft(part.text, " "))
+		// Parsed on its own rather than through CreateFunction, so b = 1 can't define b from inside a range
+		if _, _, ok := splitDefinition(part.text); ok {
+			return rhs, nil, "", &ExpressionError{"a range value cannot be a definition", offset}
+		}
+		node, err := calc.Parse(part.text)
 		if err != nil {
-			return nil, err
+			return rhs, nil, "", offsetError(err, offset)
+		}
+		if usesVariable(node, "x") {
+			return rhs, nil, "", &ExpressionError{"a range value must be constant, it cannot use x", offset}
+		}
+		if bounds[i], err = calc.evaluate(node, nil); err != nil {
+			return rhs, nil, "", offsetError(err, offset)
 		}
-		return func(x float64) (float64, error) { return val, nil }, nil
 	}
-
-	return func(x float64) (float64, error) {
-		return evaluatePostfix(postfix, tokens, x)
-	}, nil
+	if bounds[0] >= bounds[1] {
+		return rhs, nil, "", &ExpressionError{"range min must be less than max", open}
+	}
+	return strings.TrimSpace(trimmed[:open]), bounds, trimmed[open:], nil
 }
 
-func validateTokenSequence(tokens []Token) error {
-	if len(tokens) == 0 {
-		return errors.New("empty token sequence")
+// Shows or hides the slider in place of row i's response field
+func setSlider(i int, show bool) {
+	expr := &Expressions[i]
+	if show == (expr.slider != nil) {
+		return
+	}
+	if !show {
+		expr.full.RemoveItem(expr.slider)
+		expr.full.AddItem(expr.responseField, 0, 1, false)
+		expr.slider = nil
+		return
 	}
 
-	for i := 0; i < len(tokens)-1; i++ {
-		curr := tokens[i]
-		next := tokens[i+1]
-
-		// Check for invalid sequences
-		switch curr.Type {
-		case OPERATOR:
-			// Allow unary minus to follow another operator
-			if next.Type == OPERATOR {
-				if next.Value == "u-" {
-					// Disallow consecutive unary minus  which is ambiguous in current shunting yard
-					if curr.Value == "u-" {
-						return &ExpressionError{"consecutive unary minus", next.Position}
-					}
-					continue
-				}
-				return &ExpressionError{"consecutive operators", next.Position}
-			}
-		case NUMBER:
-			if next.Type == NUMBER {
-				return &ExpressionError{"missing operator between numbers", next.Position}
-			}
-			// Allow implicit multiplication between number and variable/function/constant
-			if next.Type != OPERATOR && next.Type != RPAREN {
-				continue
-			}
-		case FUNCTION:
-			if next.Type != LPAREN {
-				return &ExpressionError{"missing opening parenthesis after function", next.Position}
-			}
-		case LPAREN:
-			if next.Type == RPAREN {
-				return &ExpressionError{"empty parentheses", next.Position}
-			}
-			if next.Type == OPERATOR && next.Value != "u-" {
-				return &ExpressionError{"operator after opening parenthesis", next.Position}
-			}
-		case RPAREN:
-			if next.Type == LPAREN || next.Type == FUNCTION || next.Type == VARIABLE || next.Type == CONSTANT || next.Type == NUMBER {
-				return &ExpressionError{"missing operator after closing parenthesis", next.Position}
-			}
-		case VARIABLE:
-			// Allow implicit multiplication between variable and number/function/constant
-			if next.Type != OPERATOR && next.Type != RPAREN {
+	exprField := expr.expressionField
+	expr.slider = newSlider(expr.color, func(value float64) {
+		// Write the new value back into the row, its change handler then updates everything depending on it
+		for j := range Expressions {
+			if Expressions[j].expressionField != exprField {
 				continue
 			}
-		case CONSTANT:
-			// Allow implicit multiplication between constant and number/variable/function/constant
-			if next.Type != OPERATOR && next.Type != RPAREN {
-				continue
-			}
-		}
-	}
-
-	// Check first and last tokens
-	if len(tokens) > 0 {
-		first := tokens[0]
-		last := tokens[len(tokens)-1]
-
-		if first.Type == OPERATOR && first.Value != "-" && first.Value != "u-" {
-			return &ExpressionError{"expression cannot start with operator", first.Position}
-		}
-		if last.Type == OPERATOR {
-			return &ExpressionError{"expression cannot end with operator", last.Position}
+			text := exprField.GetText()
+			lhs := text[:strings.Index(text, "=")+1]
+			exprField.SetText(lhs + " " + Expressions[j].slider.format(value) + " " + Expressions[j].boundsText)
+			return
 		}
-	}
-
-	return nil
+	})
+	expr.full.RemoveItem(expr.responseField)
+	expr.full.AddItem(expr.slider, 0, 1, false)
 }
 
-// Evaluates the derivative at a point using a fourth-order central difference formula
-func NumericalDerivative(f func(float64) (float64, error), x float64) (float64, error) {
-	// Calculate optimal step size based on x
-	h := math.Pow(2.2e-16, 1.0/5.0) * math.Max(1.0, math.Abs(x))
-
-	a, err1 := f(x + 2*h)
-	b, err2 := f(x + h)
-	c, err3 := f(x - h)
-	d, err4 := f(x - 2*h)
-
-	if _, err0 := f(x); err0 != nil || err1 != nil || err2 != nil || err3 != nil || err4 != nil {
-		return 0, errors.New("Derivative does not exist at this point")
-	}
-	// Fourth-order central difference formula
-	return (-a + 8*b - 8*c + d) / (12 * h), nil
+// Adds a default range to a constant row, or removes its range, switching the slider on or off
+func toggleSlider(i int) {
+	expr := &Expressions[i]
+	text := expr.expressionField.GetText()
+	if expr.bounds != nil {
+		expr.expressionField.SetText(strings.TrimSpace(strings.TrimSuffix(strings.TrimRight(text, " "), expr.boundsText)))
+		return
+	}
+	value, isConstant := calc.constants[strings.ToLower(expr.name)]
+	if expr.err != nil || !isConstant {
+		InfoPrint("Only constant rows can be turned into sliders")
+		return
+	}
+	low, high := math.Min(-10, math.Floor(value)), math.Max(10, math.Ceil(value))
+	expr.expressionField.SetText(fmt.Sprintf("%s [%g, %g, 0.1]", strings.TrimRight(text, " "), low, high))
 }
 
-func Derivative(expr string) (func(float64) (float64, error), error) {
-	f, err := CreateFunction(expr)
-	if err != nil {
-		return nil, err
+// Builds the function graphed for a row, rows with several parameters can't be drawn against x alone
+func rowFunction(params []string, node Node) func(float64) (float64, error) {
+	if len(params) != 1 {
+		return nil
 	}
-
-	// Return a new function that uses numerical differentiation on inner expression function
+	param := params[0]
 	return func(x float64) (float64, error) {
-		return NumericalDerivative(f, x)
-	}, nil
+		return calc.evaluate(node, map[string]float64{param: x})
+	}
 }
 
-func tokensToString(tokens []Token) string {
-	var result strings.Builder
-	for i, token := range tokens {
-		if i > 0 && token.Type != RPAREN && tokens[i-1].Type != LPAREN {
-			result.WriteString(" ")
-		}
-		result.WriteString(token.Value)
+// Evaluates a row that doesn't use its parameter, in complex mode its value may be complex
+func rowConstant(param string, node Node) (complex128, error) {
+	if calc.IsComplex() {
+		z, err := calc.evalComplex(node, map[string]complex128{param: 0})
+		return cleanComplex(z), err
 	}
-	return result.String()
+	value, err := calc.eval(node, map[string]float64{param: 0})
+	return complex(value, 0), err
 }
 
-// evaluatePostfix evaluates a postfix token list at a given x value.
-// It mirrors the logic in CreateFunction's evaluator and supports functions and derivatives.
-func evaluatePostfix(postfix []Token, tokens []Token, x float64) (float64, error) {
-	var stack []float64
-
-	for _, token := range postfix {
-		switch token.Type {
-		case NUMBER:
-			val := 0.0
-			fmt.Sscanf(token.Value, "%f", &val)
-			stack = append(stack, val)
-
-		case VARIABLE:
-			stack = append(stack, x)
-
-		case CONSTANT:
-			stack = append(stack, mathConstants[token.Value])
+// Builds the function telling plots where a row switches between the pieces of a piecewise expression
+func rowPieces(params []string, node Node) func(float64) int {
+	if len(params) != 1 {
+		return nil
+	}
+	param := params[0]
+	return func(x float64) int {
+		return calc.pieceKey(node, map[string]float64{param: x})
+	}
+}
 
-		case OPERATOR:
-			if token.Value == "u-" {
-				if len(stack) < 1 {
-					return 0, errors.New("invalid expression: not enough operands for unary minus")
-				}
-				a := stack[len(stack)-1]
-				stack[len(stack)-1] = -a
-				break
-			}
-			if len(stack) < 2 {
-				return 0, errors.New("invalid expression: not enough operands")
-			}
-			b := stack[len(stack)-1]
-			stack = stack[:len(stack)-1]
-			a := stack[len(stack)-1]
-			stack = stack[:len(stack)-1]
-
-			var result float64
-			switch token.Value {
-			case "+":
-				result = a + b
-			case "-":
-				result = a - b
-			case "*":
-				result = a * b
-			case "/":
-				if b == 0 {
-					return 0, errors.New("division by zero")
-				}
-				result = a / b
-			case "^":
-				result = math.Pow(a, b)
-				if math.IsNaN(result) || math.IsInf(result, 0) {
-					return 0, errors.New("invalid power operation result")
+func nextUnusedYName() string {
+	max := 0
+	for _, e := range Expressions {
+		name := strings.ToLower(strings.TrimSpace(e.name))
+		if len(name) >= 2 && name[0] == 'y' {
+			numStr := name[1:]
+			if n, err := strconv.Atoi(numStr); err == nil {
+				if n > max {
+					max = n
 				}
 			}
-			stack = append(stack, result)
-
-		case FUNCTION:
-			if len(stack) < 1 {
-				return 0, errors.New("invalid expression: not enough arguments for function")
-			}
-			a := stack[len(stack)-1]
-			stack = stack[:len(stack)-1]
-
-			var result float64
-			if token.Value == "d/dx" {
-				// Get tokens for the inner expression of this d/dx occurrence
-				var innerTokens []Token
-				parenCount := 0
-				var startIndex int
-
-				// Find this specific d/dx function's tokens
-				for i := 0; i < len(tokens); i++ {
-					if tokens[i].Position == token.Position {
-						startIndex = i + 1
-						break
-					}
-				}
+		}
+	}
+	return "y" + strconv.Itoa(max+1)
+}
 
-				// Collect tokens for this specific d/dx instance
-				for i := startIndex; i < len(tokens); i++ {
-					if tokens[i].Type == LPAREN {
-						parenCount++
-						if parenCount == 1 {
-							continue // Skip the first opening parenthesis
-						}
-					} else if tokens[i].Type == RPAREN {
-						parenCount--
-						if parenCount == 0 {
-							break // Found the matching closing parenthesis
-						}
-					}
-					if parenCount > 0 {
-						innerTokens = append(innerTokens, tokens[i])
-					}
-				}
+func removeExpression(index int) {
+	// Shift names upward to keep yN ordering stable
+	for i := len(Expressions) - 1; i > index; i-- {
+		renameVariable(Expressions[i].name, Expressions[i-1].name)
+		// add something to replace all instances of expression at index with the text inside of that expression
+	}
 
-				// Create a function from these tokens directly
-				innerExpr := tokensToString(innerTokens)
-				f, err := CreateFunction(innerExpr)
-				if err != nil {
-					return 0, err
-				}
+	calc.Remove(strings.ToLower(Expressions[index].name))
+	Expressions = append(
+		Expressions[:index],
+		Expressions[index+1:]...)
+	refreshRows(-1)
+	updateExpressionBox()
+}
 
-				result, err = NumericalDerivative(f, x)
-				if err != nil {
-					return 0, err
-				}
-			} else if f, exists := userFunctions[token.Value]; exists {
-				result, _ = f(a)
-			} else {
-				// Handle built in functions
-				switch token.Value {
-				case "ln":
-					if a <= 0 {
-						return 0, fmt.Errorf("domain error: ln(%f) - logarithm of non-positive number", a)
-					}
-					result = math.Log(a)
-				case "sqrt":
-					if a < 0 {
-						return 0, fmt.Errorf("domain error: sqrt(%f) - square root of negative number", a)
-					}
-					result = math.Sqrt(a)
-				case "asin", "acos":
-					if a < -1 || a > 1 {
-						return 0, fmt.Errorf("domain error: %s(%f) - argument must be between -1 and 1", token.Value, a)
-					}
-					if token.Value == "asin" {
-						result = math.Asin(a)
-					} else {
-						result = math.Acos(a)
-					}
-				default:
-					result = mathFuncs[token.Value](a)
-				}
-			}
+func renameVariable(originalName string, newName string) {
+	// Replace occurrences of the originalName with newName in all expressions
+	// and re-create their functions to keep everything consistent.
+	for i := range Expressions {
+		// Skip the expression that's currently being renamed to avoid recursive change callbacks
+		if i == focusedExpressionIndex {
+			continue
+		}
 
-			// Check for general domain errors
-			if math.IsNaN(result) || math.IsInf(result, 0) {
-				return 0, fmt.Errorf("domain error: %s(%f) produced an invalid result", token.Value, a)
-			}
-			stack = append(stack, result)
+		// Update the visible input text for other expressions
+		fieldText := Expressions[i].expressionField.GetText()
+		fieldUpdated := strings.ReplaceAll(fieldText, originalName, newName)
+		if fieldUpdated != fieldText {
+			Expressions[i].expressionField.SetText(fieldUpdated)
 		}
-	}
 
-	if len(stack) != 1 {
-		return 0, errors.New("invalid expression: incorrect number of values in final stack")
+		// Update the stored RHS expression
+		updated := strings.ReplaceAll(Expressions[i].formationString, originalName, newName)
+		if updated != Expressions[i].formationString {
+			Expressions[i].formationString = updated
+			evaluateRow(i)
+		}
 	}
-
-	return stack[0], nil
+	queResponseUpdate = true
+	queGraphUpdate = true
 }
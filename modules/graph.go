@@ -12,13 +12,12 @@ import (
 	"github.com/rivo/tview"
 )
 
-var Graph = tview.NewImage().
-	SetDithering(tview.DitheringNone).
-	SetColors(tview.TrueColor)
+var Graph = newGraphView()
 
 const GraphSize = 16 // graph proportionality in the flex that it is inside of, all other components scale off of it
 var lastImageWidth = 800
 var lastImageHeight = 600
+var lastGraphWidth, lastGraphHeight = 0, 0 // size of the graph in terminal cells
 var queRedraw = false
 
 // Mutable graph bounds
@@ -26,14 +25,15 @@ var viewXMin = -10.0
 var viewXMax = 10.0
 var viewYMin = -5.0
 var viewYMax = 5.0
+var showSlopeField = false // draw the slope field of first order differential equation rows
 
 func GraphTraversial() *tview.Flex {
 	// Vertical, expression-like rows: each parameter is one row
 	// with a title label in front of the input, matching outline & height.
-	format := func(v float64) string { return fmt.Sprintf("%g", v) }
+	format := func(v float64) string { return fmt.Sprintf("%.6g", v) }
 
 	// Helper to make a row structured like expression rows
-	makeRow := func(field *tview.InputField) *tview.Flex {
+	makeRow := func(field tview.Primitive) *tview.Flex {
 		return tview.NewFlex().SetDirection(tview.FlexColumnCSS).
 			AddItem(
 				tview.NewFlex().SetDirection(tview.FlexRowCSS).
@@ -71,7 +71,7 @@ func GraphTraversial() *tview.Flex {
 		SetFieldBackgroundColor(tcell.ColorWhite).
 		SetText(format(viewYMax))
 
-	// Commit handlers: apply on Enter and validate
+	// Commit handlers: apply on Enter and validate, setView holds typed bounds to the same limits as the mouse
 	xMinField.SetDoneFunc(func(key tcell.Key) {
 		if key != tcell.KeyEnter {
 			return
@@ -81,8 +81,9 @@ func GraphTraversial() *tview.Flex {
 				InfoPrint("X min must be less than X max")
 				return
 			}
-			viewXMin = f
-			RedrawGraph()
+			if !setView(f, viewXMax, viewYMin, viewYMax) {
+				InfoPrint("X range is too narrow or too wide to draw")
+			}
 		} else {
 			InfoPrint("Invalid X min")
 		}
@@ -96,8 +97,9 @@ func GraphTraversial() *tview.Flex {
 				InfoPrint("X max must be greater than X min")
 				return
 			}
-			viewXMax = f
-			RedrawGraph()
+			if !setView(viewXMin, f, viewYMin, viewYMax) {
+				InfoPrint("X range is too narrow or too wide to draw")
+			}
 		} else {
 			InfoPrint("Invalid X max")
 		}
@@ -112,8 +114,9 @@ func GraphTraversial() *tview.Flex {
 				InfoPrint("Y min must be less than Y max")
 				return
 			}
-			viewYMin = f
-			RedrawGraph()
+			if !setView(viewXMin, viewXMax, f, viewYMax) {
+				InfoPrint("Y range is too narrow or too wide to draw")
+			}
 		} else {
 			InfoPrint("Invalid Y min")
 		}
@@ -127,42 +130,126 @@ func GraphTraversial() *tview.Flex {
 				InfoPrint("Y max must be greater than Y min")
 				return
 			}
-			viewYMax = f
-			RedrawGraph()
+			if !setView(viewXMin, viewXMax, viewYMin, f) {
+				InfoPrint("Y range is too narrow or too wide to draw")
+			}
 		} else {
 			InfoPrint("Invalid Y max")
 		}
 	})
 
-	// Layout: three rows; each row contains two fields side by side
+	// Zooming and panning with the mouse moves the view from outside the fields
+	updateBoundsFields = func() {
+		xMinField.SetText(format(viewXMin))
+		xMaxField.SetText(format(viewXMax))
+		yMinField.SetText(format(viewYMin))
+		yMaxField.SetText(format(viewYMax))
+	}
+
+	// How the graph is drawn and the grid behind it
+	rendererDropDown := tview.NewDropDown().
+		SetLabel("Render: ").
+		SetFieldTextColor(tcell.ColorBlack).
+		SetFieldBackgroundColor(tcell.ColorWhite).
+		SetOptions(rendererNames, func(_ string, index int) {
+			graphRenderer = renderer(index)
+			RedrawGraph()
+		}).
+		SetCurrentOption(int(graphRenderer))
+	gridDropDown := tview.NewDropDown().
+		SetLabel("Grid: ").
+		SetFieldTextColor(tcell.ColorBlack).
+		SetFieldBackgroundColor(tcell.ColorWhite).
+		SetOptions(gridNames, func(_ string, index int) {
+			showGrid = gridLines(index)
+			RedrawGraph()
+		}).
+		SetCurrentOption(int(showGrid))
+
+	// Layout: three rows of three fields side by side
 	row1 := tview.NewFlex().SetDirection(tview.FlexRowCSS).
 		AddItem(makeRow(xMinField), 0, 1, true).
 		AddItem(tview.NewBox(), 1, 0, false).
-		AddItem(makeRow(xMaxField), 0, 1, false)
+		AddItem(makeRow(xMaxField), 0, 1, false).
+		AddItem(tview.NewBox(), 1, 0, false).
+		AddItem(makeRow(rendererDropDown), 0, 1, false)
 
-	// Second row: Y min | Y max
+	// Second row: Y min | Y max | Grid
 	row2 := tview.NewFlex().SetDirection(tview.FlexRowCSS).
 		AddItem(makeRow(yMinField), 0, 1, false).
 		AddItem(tview.NewBox(), 1, 0, false).
-		AddItem(makeRow(yMaxField), 0, 1, false)
+		AddItem(makeRow(yMaxField), 0, 1, false).
+		AddItem(tview.NewBox(), 1, 0, false).
+		AddItem(makeRow(gridDropDown), 0, 1, false)
+
+	// Third row: evaluation mode, slope fields and the digits shown for constant rows
+	complexCheckbox := tview.NewCheckbox().
+		SetLabel("Complex: ").
+		SetFieldBackgroundColor(checkboxColor).
+		SetFieldTextColor(tcell.ColorBlack).
+		SetChecked(calc.IsComplex()).
+		SetChangedFunc(func(checked bool) {
+			calc.SetComplex(checked)
+			refreshRows(-1)
+			queResponseUpdate = true
+			RedrawGraph()
+		})
+	digitsField := tview.NewInputField().
+		SetLabel("Digits: ").
+		SetFieldTextColor(tcell.ColorBlack).
+		SetFieldBackgroundColor(tcell.ColorWhite).
+		SetPlaceholder("off").
+		SetPlaceholderTextColor(tcell.ColorGray)
+	digitsField.SetDoneFunc(func(key tcell.Key) {
+		if key != tcell.KeyEnter {
+			return
+		}
+		digits := 0
+		if text := digitsField.GetText(); text != "" {
+			n, err := strconv.Atoi(text)
+			if err != nil || n < 0 || n > 1000 {
+				InfoPrint("Digits must be a whole number from 0 to 1000, 0 turns precise values off")
+				return
+			}
+			digits = n
+		}
+		preciseDigits = digits
+		refreshRows(-1)
+		queResponseUpdate = true
+	})
+	slopesCheckbox := tview.NewCheckbox().
+		SetLabel("Slopes: ").
+		SetFieldBackgroundColor(checkboxColor).
+		SetFieldTextColor(tcell.ColorBlack).
+		SetChecked(showSlopeField).
+		SetChangedFunc(func(checked bool) {
+			showSlopeField = checked
+			RedrawGraph()
+		})
+	row3 := tview.NewFlex().SetDirection(tview.FlexRowCSS).
+		AddItem(makeRow(complexCheckbox), 0, 1, false).
+		AddItem(tview.NewBox(), 1, 0, false).
+		AddItem(makeRow(slopesCheckbox), 0, 1, false).
+		AddItem(tview.NewBox(), 1, 0, false).
+		AddItem(makeRow(digitsField), 0, 1, false)
 
 	controls := tview.NewFlex().SetDirection(tview.FlexColumnCSS)
 	// Reduce vertical spacing by tightening row heights
 	controls.AddItem(row1, 2, 0, true)
 	controls.AddItem(row2, 2, 0, false)
+	controls.AddItem(row3, 2, 0, false)
 
 	return controls
 }
 
 // Updates BEFORE frame is drawn, returns true if drawing should not occur
 func GraphUpdate() bool {
-	// redraws graph if the dimensions of the image have changed
+	// redraws graph if its dimensions have changed, and with them how many ticks there is room to label
 	_, _, newWidth, newHeight := Graph.GetRect()
-	if newWidth != lastImageWidth || newHeight != lastImageHeight {
+	if newWidth != lastGraphWidth || newHeight != lastGraphHeight {
+		lastGraphWidth, lastGraphHeight = newWidth, newHeight
 		queRedraw = true // delays redraw to when the window is no longer being resized
-	} else if queRedraw && newWidth == lastImageWidth && newHeight == lastImageHeight {
-		lastImageWidth = newWidth
-		lastImageHeight = newHeight
+	} else if queRedraw {
 		RedrawGraph()
 		queRedraw = false
 	}
@@ -170,15 +257,30 @@ func GraphUpdate() bool {
 }
 
 func RedrawGraph() {
-	Graph.SetImage(createGraph())
+	Graph.cells = nil // the cell renderers draw the graph again on the next frame
+	if graphRenderer == imageRenderer {
+		Graph.image.SetImage(createGraph())
+	}
 }
 
-const (
-	lineWidth   = 20 // Width of the function line
-	axisWidth   = 20 // Width of the axes
-	tickLength  = 50 // Length of tick marks
-	tickWidth   = 20 // Width of tick marks
-	tickSpacing = 50 // Space between ticks in graph units
+// Sizes in pixels of what is drawn on the graph. The image renderer draws big for tview to scale
+// the image down to the terminal, the cell renderers draw one pixel per braille dot or half block.
+type strokes struct {
+	line         int // Width of the function line
+	mark         int // Width of the marks of a slope field
+	marker       int // Width of the markers of table points
+	axis         int // Width of the axes
+	tick         int // Width of tick marks
+	tickLength   int // Length of tick marks
+	grid         int // Width of grid lines
+	slopeSpacing int // Space between the marks of a slope field
+	implicitCell int // Side of a marching squares cell
+}
+
+var (
+	imageStrokes = strokes{line: 20, mark: 8, marker: 36, axis: 20, tick: 12, tickLength: 50, grid: 6, slopeSpacing: 40, implicitCell: 4}
+	cellStrokes  = strokes{line: 1, mark: 1, marker: 3, axis: 1, tick: 1, tickLength: 3, grid: 1, slopeSpacing: 8, implicitCell: 1}
+	stroke       = imageStrokes // sizes for the graph being drawn
 )
 
 // map a value from one range to another
@@ -188,18 +290,22 @@ func mapRange(value, inMin, inMax, outMin, outMax float64) float64 {
 
 // generate an image of all function expressions on a cartesian coordinate plane
 func createGraph() image.Image {
+	defer calc.limitSeries(plotSeriesTerms)()
 	img := image.NewRGBA(image.Rect(0, 0, lastImageWidth, lastImageHeight))
 
 	// Fill background with white
 	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
 
+	xStep, yStep := tickSteps()
+	drawGrid(img, xStep, yStep)
+
 	// Draw axes
 	xAxis := int(mapRange(0, viewYMin, viewYMax, float64(lastImageHeight-1), 0))
 	yAxis := int(mapRange(0, viewXMin, viewXMax, 0, float64(lastImageWidth-1)))
 	for x := 0; x < lastImageWidth; x++ {
-		for dy := -axisWidth / 2; dy <= axisWidth/2; dy++ {
-			for dx := -axisWidth / 2; dx <= axisWidth/2; dx++ {
-				if dx*dx+dy*dy <= (axisWidth/2)*(axisWidth/2) {
+		for dy := -stroke.axis / 2; dy <= stroke.axis/2; dy++ {
+			for dx := -stroke.axis / 2; dx <= stroke.axis/2; dx++ {
+				if dx*dx+dy*dy <= (stroke.axis/2)*(stroke.axis/2) {
 					y := xAxis + dy
 					if y >= 0 && y < lastImageHeight && x+dx >= 0 && x+dx < lastImageWidth {
 						img.Set(x+dx, y, color.Black)
@@ -209,9 +315,9 @@ func createGraph() image.Image {
 		}
 	}
 	for y := 0; y < lastImageHeight; y++ {
-		for dx := -axisWidth / 2; dx <= axisWidth/2; dx++ {
-			for dy := -axisWidth / 2; dy <= axisWidth/2; dy++ {
-				if dx*dx+dy*dy <= (axisWidth/2)*(axisWidth/2) {
+		for dx := -stroke.axis / 2; dx <= stroke.axis/2; dx++ {
+			for dy := -stroke.axis / 2; dy <= stroke.axis/2; dy++ {
+				if dx*dx+dy*dy <= (stroke.axis/2)*(stroke.axis/2) {
 					x := yAxis + dx
 					if x >= 0 && x < lastImageWidth && y+dy >= 0 && y+dy < lastImageHeight {
 						img.Set(x, y+dy, color.Black)
@@ -221,104 +327,546 @@ func createGraph() image.Image {
 		}
 	}
 
-	///// Not visable when image is compressed
-	// // Draw tick marks
-	// for x := math.Ceil(xMin/tickSpacing) * tickSpacing; x <= xMax; x += tickSpacing {
-	// 	px := int(mapRange(x, xMin, xMax, 0, float64(lastImageWidth-1)))
-	// 	if px >= 0 && px < lastImageWidth {
-	// 		for dy := -tickLength / 2; dy <= tickLength/2; dy++ {
-	// 			for dx := -tickWidth / 2; dx <= tickWidth/2; dx++ {
-	// 				if dx*dx+dy*dy <= (tickWidth/2)*(tickWidth/2) {
-	// 					y := xAxis + dy
-	// 					if y >= 0 && y < lastImageHeight && px+dx >= 0 && px+dx < lastImageWidth {
-	// 						img.Set(px+dx, y, color.Black)
-	// 					}
-	// 				}
-	// 			}
-	// 		}
-	// 	}
-	// }
-	// for y := math.Ceil(yMin/tickSpacing) * tickSpacing; y <= yMax; y += tickSpacing {
-	// 	py := int(mapRange(y, yMin, yMax, float64(lastImageHeight-1), 0))
-	// 	if py >= 0 && py < lastImageHeight {
-	// 		for dx := -tickLength / 2; dx <= tickLength/2; dx++ {
-	// 			for dy := -tickWidth / 2; dy <= tickWidth/2; dy++ {
-	// 				if dx*dx+dy*dy <= (tickWidth/2)*(tickWidth/2) {
-	// 					x := yAxis + dx
-	// 					if x >= 0 && x < lastImageWidth && py+dy >= 0 && py+dy < lastImageHeight {
-	// 						img.Set(x, py+dy, color.Black)
-	// 					}
-	// 				}
-	// 			}
-	// 		}
-	// 	}
-	// }
+	// Tick marks at the labeled values
+	for _, x := range multiples(viewXMin, viewXMax, xStep) {
+		px, _ := toImage(x, 0)
+		drawLine(img, px, xAxis-stroke.tickLength/2, px, xAxis+stroke.tickLength/2, stroke.tick, color.Black)
+	}
+	for _, y := range multiples(viewYMin, viewYMax, yStep) {
+		_, py := toImage(0, y)
+		drawLine(img, yAxis-stroke.tickLength/2, py, yAxis+stroke.tickLength/2, py, stroke.tick, color.Black)
+	}
+
+	// Shade inequality regions and integrals first so every curve stays visible on top of them
+	for _, expr := range Expressions {
+		if expr.err != nil || !expr.enabledCheckbox.IsChecked() {
+			continue
+		}
+		switch {
+		case expr.region != nil:
+			shadeRegion(img, expr)
+		case expr.area != nil:
+			shadeArea(img, expr)
+		case expr.ode != nil && expr.ode.order == 1 && showSlopeField:
+			drawSlopeField(img, expr)
+		}
+	}
 
 	// Plot all function expressions
+	for _, expr := range Expressions {
+		if expr.err != nil || !expr.enabledCheckbox.IsChecked() {
+			continue
+		}
+		switch {
+		case expr.area != nil:
+			// the shaded area stands for an integral row, rather than a line at its value
+		case expr.implicit != nil:
+			plotImplicit(img, expr)
+		case expr.curve != nil:
+			plotCurve(img, expr)
+		case expr.ode != nil:
+			plotODE(img, expr)
+		case expr.table != nil:
+			plotTable(img, expr)
+		case expr.function != nil:
+			plotFunction(img, expr)
+		}
+	}
+
+	return img
+}
+
+const (
+	functionSegments = 400  // even segments y = f(x) starts from before refining, two image pixels wide
+	functionMaxDepth = 5    // times a segment may be halved, down to a sixteenth of a pixel
+	functionMaxTurn  = 0.05 // radians the graph may turn across a segment before it is split
+	jumpCheckHeight  = 4    // image pixels a segment has to rise or fall before it is checked for a break
+	jumpBisections   = 50   // halvings spent looking for where a steep segment breaks
+)
+
+// A point of a function's graph, ok is false where the function has no value
+type functionSample struct {
+	x, y float64
+	ok   bool
+}
+
+// Plots y = f(x) across the view, sampled more finely where the graph bends and lifting the pen
+// at poles and jumps so tan(x) and 1/x don't get walls joining their branches
+func plotFunction(img *image.RGBA, expr expression) {
+	lineColor := convertColorType(expr.color)
+	var samples []functionSample
+	checkBreaks := true
+	if expr.integrand != nil {
+		// Running integrals are summed up across an even grid at once, each step only integrating from the last sample
+		xs := make([]float64, lastImageWidth*2)
+		for i := range xs {
+			xs[i] = mapRange(float64(i), 0, float64(len(xs)-1), viewXMin, viewXMax)
+		}
+		running, valid := cumulativeIntegral(expr.integrand, expr.lower, xs)
+		for i, x := range xs {
+			samples = append(samples, functionSample{x, running[i], valid[i]})
+		}
+		checkBreaks = false
+	} else {
+		samples = functionSamples(expr.function, viewXMin, viewXMax)
+	}
 
-	for _, expression := range Expressions {
-		if expression.err != nil || !expression.enabledCheckbox.IsChecked() {
+	line := &pen{img: img, color: lineColor}
+	prevPiece := 0
+	for i, s := range samples {
+		if !s.ok {
+			line.lift()
 			continue
 		}
-		resolution := lastImageWidth * 2
-		prevY := -1
-		prevX := -1
+		// Never join two samples taken from different pieces of a piecewise row
+		if expr.pieces != nil {
+			if piece := expr.pieces(s.x); piece != prevPiece {
+				line.lift()
+				prevPiece = piece
+			}
+		}
+		if before := samples[max(i-1, 0)]; checkBreaks && i > 0 && before.ok && breaksBetween(expr.function, before, s) {
+			line.lift()
+		}
+		line.to(s.x, s.y)
+	}
+}
+
+// Samples f from x0 to x1, halving each segment of an even grid while the graph turns by more than
+// functionMaxTurn across it on screen, or while f has a value at one end and not the other
+func functionSamples(f func(float64) (float64, error), x0, x1 float64) []functionSample {
+	at := func(x float64) functionSample {
+		y, err := f(x)
+		return functionSample{x, y, err == nil && !math.IsNaN(y) && !math.IsInf(y, 0)}
+	}
+	// Screen space direction of the graph from a to b, so bends are judged the way they will be drawn
+	direction := func(a, b functionSample) float64 {
+		dx := mapRange(b.x, x0, x1, 0, float64(lastImageWidth-1)) - mapRange(a.x, x0, x1, 0, float64(lastImageWidth-1))
+		dy := (b.y - a.y) * float64(lastImageHeight-1) / (viewYMax - viewYMin)
+		return math.Atan2(dy, dx)
+	}
 
-		for i := 0; i < resolution; i++ {
-			// Map x coordinate
-			xVal := mapRange(float64(i), 0, float64(resolution-1), viewXMin, viewXMax)
-			yVal, err := expression.function(xVal)
+	samples := []functionSample{at(x0)}
+	var refine func(a, b functionSample, depth int)
+	refine = func(a, b functionSample, depth int) {
+		m := at((a.x + b.x) / 2)
+		if depth < functionMaxDepth {
+			split := a.ok != b.ok || a.ok != m.ok
+			if a.ok && m.ok && b.ok {
+				split = math.Abs(direction(m, b)-direction(a, m)) > functionMaxTurn
+			}
+			if split {
+				refine(a, m, depth+1)
+				refine(m, b, depth+1)
+				return
+			}
+		}
+		samples = append(samples, m, b)
+	}
+	for i := 0; i < functionSegments; i++ {
+		a := samples[len(samples)-1]
+		refine(a, at(mapRange(float64(i+1), 0, functionSegments, x0, x1)), 0)
+	}
+	return samples
+}
+
+// Reports whether f breaks between two samples, by a pole or a jump, rather than running steeply from
+// one to the other. The half that changes more is kept at every halving: across a continuous stretch its
+// change soon drops below a pixel, across a jump it stays the size of the jump and across a pole it grows.
+func breaksBetween(f func(float64) (float64, error), a, b functionSample) bool {
+	pixel := (viewYMax - viewYMin) / float64(lastImageHeight-1)
+	if math.Abs(b.y-a.y) < jumpCheckHeight*pixel {
+		return false
+	}
+	for i := 0; i < jumpBisections; i++ {
+		mx := (a.x + b.x) / 2
+		if mx <= a.x || mx >= b.x {
+			break // the segment can't be halved any further, the change is all at one point
+		}
+		y, err := f(mx)
+		if err != nil || math.IsNaN(y) || math.IsInf(y, 0) {
+			return true
+		}
+		m := functionSample{mx, y, true}
+		if math.Abs(m.y-a.y) > math.Abs(b.y-m.y) {
+			b = m
+		} else {
+			a = m
+		}
+		if math.Abs(b.y-a.y) < pixel {
+			return false
+		}
+	}
+	return true
+}
+
+// Plots a parametric or polar row by stepping its parameter across tRange,
+// the pen is lifted wherever a sample fails
+func plotCurve(img *image.RGBA, expr expression) {
+	var params []float64
+	if expr.adaptive {
+		params = curvatureSamples(expr.curve, expr.tRange[0], expr.tRange[1])
+	} else {
+		for i := 0; i < expr.samples; i++ {
+			params = append(params, mapRange(float64(i), 0, float64(expr.samples-1), expr.tRange[0], expr.tRange[1]))
+		}
+	}
+
+	line := &pen{img: img, color: convertColorType(expr.color)}
+	for _, t := range params {
+		x, y, err := expr.curve(t)
+		if err != nil || math.IsNaN(x) || math.IsNaN(y) {
+			line.lift()
+			continue
+		}
+		line.to(x, y)
+	}
+}
+
+const (
+	curveSegments = 128  // even segments a curve starts from before refining
+	curveMaxDepth = 8    // times a segment may be halved, so at most 256 pieces each
+	curveMaxTurn  = 0.05 // radians the curve may turn across a segment before it is split
+)
+
+// Picks parameter values along a curve so the samples bunch up where it bends sharply and thin out
+// where it runs straight: each segment of an even grid is halved while the curve turns by more
+// than curveMaxTurn across it on screen
+func curvatureSamples(curve func(float64) (float64, float64, error), t0, t1 float64) []float64 {
+	// Screen space position of the curve at t, so bends are judged the way they will be drawn
+	at := func(t float64) (float64, float64, bool) {
+		x, y, err := curve(t)
+		if err != nil || math.IsNaN(x) || math.IsNaN(y) {
+			return 0, 0, false
+		}
+		return mapRange(x, viewXMin, viewXMax, 0, float64(lastImageWidth-1)),
+			mapRange(y, viewYMin, viewYMax, float64(lastImageHeight-1), 0), true
+	}
+
+	params := []float64{t0}
+	var refine func(ta, tb float64, depth int)
+	refine = func(ta, tb float64, depth int) {
+		tm := (ta + tb) / 2
+		ax, ay, okA := at(ta)
+		mx, my, okM := at(tm)
+		bx, by, okB := at(tb)
+		if depth < curveMaxDepth && okA && okM && okB {
+			turn := math.Abs(math.Remainder(math.Atan2(by-my, bx-mx)-math.Atan2(my-ay, mx-ax), 2*math.Pi))
+			if turn > curveMaxTurn {
+				refine(ta, tm, depth+1)
+				refine(tm, tb, depth+1)
+				return
+			}
+		}
+		params = append(params, tm, tb)
+	}
+	for i := 0; i < curveSegments; i++ {
+		refine(mapRange(float64(i), 0, curveSegments, t0, t1), mapRange(float64(i+1), 0, curveSegments, t0, t1), 0)
+	}
+	return params
+}
+
+// Draws the curve where an equation row's two sides agree by marching squares: the difference of the
+// sides is sampled on a grid over the view, and every cell whose corners disagree in sign gets a segment
+// between the interpolated crossings on its edges
+func plotImplicit(img *image.RGBA, expr expression) {
+	cols, rows := lastImageWidth/stroke.implicitCell, lastImageHeight/stroke.implicitCell
+	if cols < 1 || rows < 1 {
+		return
+	}
+	px := func(i float64) float64 { return mapRange(i, 0, float64(cols), 0, float64(lastImageWidth-1)) }
+	py := func(j float64) float64 { return mapRange(j, 0, float64(rows), 0, float64(lastImageHeight-1)) }
+
+	// Values at the grid corners, NaN where the equation can't be evaluated
+	values := make([][]float64, rows+1)
+	for j := range values {
+		values[j] = make([]float64, cols+1)
+		y := mapRange(float64(j), 0, float64(rows), viewYMax, viewYMin)
+		for i := range values[j] {
+			v, err := expr.implicit(mapRange(float64(i), 0, float64(cols), viewXMin, viewXMax), y)
 			if err != nil {
+				v = math.NaN()
+			}
+			values[j][i] = v
+		}
+	}
+
+	lineColor := convertColorType(expr.color)
+	for j := 0; j < rows; j++ {
+		for i := 0; i < cols; i++ {
+			// Corners clockwise from the top left, with their grid positions
+			corners := [4]float64{values[j][i], values[j][i+1], values[j+1][i+1], values[j+1][i]}
+			positions := [4][2]float64{{float64(i), float64(j)}, {float64(i + 1), float64(j)}, {float64(i + 1), float64(j + 1)}, {float64(i), float64(j + 1)}}
+			if math.IsNaN(corners[0]) || math.IsNaN(corners[1]) || math.IsNaN(corners[2]) || math.IsNaN(corners[3]) {
 				continue
 			}
 
-			// Map to image coordinates
-			if yVal >= viewYMin && yVal <= viewYMax {
-				px := int(mapRange(float64(i), 0, float64(resolution-1), 0, float64(lastImageWidth-1)))
-				py := int(mapRange(yVal, viewYMin, viewYMax, float64(lastImageHeight-1), 0))
-
-				if py >= 0 && py < lastImageHeight && px >= 0 && px < lastImageWidth {
-					// Draw point with circular thickness
-					for dx := -lineWidth / 2; dx <= lineWidth/2; dx++ {
-						for dy := -lineWidth / 2; dy <= lineWidth/2; dy++ {
-							if dx*dx+dy*dy <= (lineWidth/2)*(lineWidth/2) {
-								if px+dx >= 0 && px+dx < lastImageWidth && py+dy >= 0 && py+dy < lastImageHeight {
-									img.Set(px+dx, py+dy, convertColorType(expression.color))
-								}
-							}
-						}
-					}
+			// Crossings on the top, right, bottom and left edges, in that order
+			var crossings [][2]int
+			for edge := 0; edge < 4; edge++ {
+				a, b := corners[edge], corners[(edge+1)%4]
+				if (a > 0) == (b > 0) {
+					continue
+				}
+				t := a / (a - b)
+				pa, pb := positions[edge], positions[(edge+1)%4]
+				crossings = append(crossings, [2]int{
+					int(px(pa[0] + t*(pb[0]-pa[0]))),
+					int(py(pa[1] + t*(pb[1]-pa[1]))),
+				})
+			}
 
-					// Fill gaps between points
-					if prevY != -1 && prevX != -1 {
-						dx := px - prevX
-						dy := py - prevY
-						steps := int(math.Max(math.Abs(float64(dx)), math.Abs(float64(dy))))
-						if steps > 0 {
-							for step := 0; step <= steps; step++ {
-								x := prevX + dx*step/steps
-								y := prevY + dy*step/steps
-								// Draw circular points along the line
-								for ddx := -lineWidth / 2; ddx <= lineWidth/2; ddx++ {
-									for ddy := -lineWidth / 2; ddy <= lineWidth/2; ddy++ {
-										if ddx*ddx+ddy*ddy <= (lineWidth/2)*(lineWidth/2) {
-											if x+ddx >= 0 && x+ddx < lastImageWidth && y+ddy >= 0 && y+ddy < lastImageHeight {
-												img.Set(x+ddx, y+ddy, convertColorType(expression.color))
-											}
-										}
-									}
-								}
-							}
-						}
-					}
-					prevX = px
-					prevY = py
+			switch len(crossings) {
+			case 2:
+				drawSegment(img, crossings[0][0], crossings[0][1], crossings[1][0], crossings[1][1], lineColor)
+			case 4:
+				// Saddle, the value at the center decides which pairs of crossings belong together
+				center := (corners[0] + corners[1] + corners[2] + corners[3]) / 4
+				if (center > 0) == (corners[0] > 0) {
+					drawSegment(img, crossings[0][0], crossings[0][1], crossings[1][0], crossings[1][1], lineColor)
+					drawSegment(img, crossings[2][0], crossings[2][1], crossings[3][0], crossings[3][1], lineColor)
+				} else {
+					drawSegment(img, crossings[0][0], crossings[0][1], crossings[3][0], crossings[3][1], lineColor)
+					drawSegment(img, crossings[1][0], crossings[1][1], crossings[2][0], crossings[2][1], lineColor)
 				}
 			}
 		}
 	}
+}
 
-	return img
+const regionAlpha = 80 // opacity of shaded regions out of 255, low enough to see the graph beneath
+
+// Draws a marker at every point of a table row that lies in the view
+func plotTable(img *image.RGBA, expr expression) {
+	markerColor := convertColorType(expr.color)
+	for i, x := range expr.table.xs {
+		y := expr.table.ys[i]
+		if x < viewXMin || x > viewXMax || y < viewYMin || y > viewYMax {
+			continue
+		}
+		px := int(mapRange(x, viewXMin, viewXMax, 0, float64(lastImageWidth-1)))
+		py := int(mapRange(y, viewYMin, viewYMax, float64(lastImageHeight-1), 0))
+		drawDisc(img, px, py, stroke.marker, markerColor)
+	}
+}
+
+// Plots the solution of a differential equation row, solved across the part of the view inside its range
+func plotODE(img *image.RGBA, expr expression) {
+	from, to := math.Max(viewXMin, expr.tRange[0]), math.Min(viewXMax, expr.tRange[1])
+	if from >= to {
+		return
+	}
+	xs, ys := calc.solveODE(expr.ode, from, to)
+	line := &pen{img: img, color: convertColorType(expr.color)}
+	for i, x := range xs {
+		if x < from || x > to {
+			line.lift() // the solution is only drawn inside the row's range
+			continue
+		}
+		line.to(x, ys[i])
+	}
+}
+
+// Draws a short mark with the slope of a first order differential equation row at the center
+// of every cell of a grid across the view, the solutions follow along the marks
+func drawSlopeField(img *image.RGBA, expr expression) {
+	// Marks are a paler version of the row color so the solution stands out from them
+	r, g, b := expr.color.RGB()
+	markColor := color.RGBA{uint8((r + 255) / 2), uint8((g + 255) / 2), uint8((b + 255) / 2), 255}
+	half := float64(stroke.slopeSpacing) * 0.35
+	for top := 0; top < lastImageHeight; top += stroke.slopeSpacing {
+		cy := float64(top) + float64(stroke.slopeSpacing)/2
+		y := mapRange(cy, 0, float64(lastImageHeight-1), viewYMax, viewYMin)
+		for left := 0; left < lastImageWidth; left += stroke.slopeSpacing {
+			cx := float64(left) + float64(stroke.slopeSpacing)/2
+			x := mapRange(cx, 0, float64(lastImageWidth-1), viewXMin, viewXMax)
+			slope, err := calc.derivatives(expr.ode, x, []float64{y})
+			if err != nil || math.IsNaN(slope[0]) {
+				continue
+			}
+			// The slope in image pixels, y grows downwards in the image
+			dx := float64(lastImageWidth-1) / (viewXMax - viewXMin)
+			dy := -slope[0] * float64(lastImageHeight-1) / (viewYMax - viewYMin)
+			length := math.Hypot(dx, dy)
+			if math.IsInf(length, 0) {
+				dx, dy, length = 0, 1, 1
+			}
+			dx, dy = dx/length*half, dy/length*half
+			drawLine(img, int(cx-dx), int(cy-dy), int(cx+dx), int(cy+dy), stroke.mark, markColor)
+		}
+	}
+}
+
+// Shades an inequality row by testing its condition at the center of every marching squares sized cell
+// and tinting the cells where it holds with a translucent version of the row color
+func shadeRegion(img *image.RGBA, expr expression) {
+	r, g, b := expr.color.RGB()
+	fill := &image.Uniform{color.NRGBA{uint8(r), uint8(g), uint8(b), regionAlpha}}
+	for top := 0; top < lastImageHeight; top += stroke.implicitCell {
+		y := mapRange(float64(top)+float64(stroke.implicitCell)/2, 0, float64(lastImageHeight-1), viewYMax, viewYMin)
+		for left := 0; left < lastImageWidth; left += stroke.implicitCell {
+			x := mapRange(float64(left)+float64(stroke.implicitCell)/2, 0, float64(lastImageWidth-1), viewXMin, viewXMax)
+			if inside, err := expr.region(x, y); err == nil && inside {
+				draw.Draw(img, image.Rect(left, top, left+stroke.implicitCell, top+stroke.implicitCell), fill, image.Point{}, draw.Over)
+			}
+		}
+	}
+}
+
+// Shades the area an integral row measures, between its integrand and the x axis over the bounds,
+// one column of image pixels at a time
+func shadeArea(img *image.RGBA, expr expression) {
+	r, g, b := expr.color.RGB()
+	fill := &image.Uniform{color.NRGBA{uint8(r), uint8(g), uint8(b), regionAlpha}}
+	lo, hi := math.Min(expr.areaRange[0], expr.areaRange[1]), math.Max(expr.areaRange[0], expr.areaRange[1])
+	left := max(0, int(math.Ceil(mapRange(lo, viewXMin, viewXMax, 0, float64(lastImageWidth-1)))))
+	right := min(lastImageWidth-1, int(math.Floor(mapRange(hi, viewXMin, viewXMax, 0, float64(lastImageWidth-1)))))
+	axis := mapRange(0, viewYMin, viewYMax, float64(lastImageHeight-1), 0)
+	for px := left; px <= right; px++ {
+		y, err := expr.area(mapRange(float64(px), 0, float64(lastImageWidth-1), viewXMin, viewXMax))
+		if err != nil || math.IsNaN(y) {
+			continue
+		}
+		py := mapRange(y, viewYMin, viewYMax, float64(lastImageHeight-1), 0)
+		top, bottom := math.Max(0, math.Min(axis, py)), math.Min(float64(lastImageHeight), math.Max(axis, py))
+		if top < bottom {
+			draw.Draw(img, image.Rect(px, int(top), px+1, int(bottom)), fill, image.Point{}, draw.Over)
+		}
+	}
+}
+
+// Draw point with circular thickness
+func drawDot(img *image.RGBA, px, py int, c color.Color) {
+	drawDisc(img, px, py, stroke.line, c)
+}
+
+// Draws a filled circle of the given width centered on a point
+func drawDisc(img *image.RGBA, px, py, width int, c color.Color) {
+	for dx := -width / 2; dx <= width/2; dx++ {
+		for dy := -width / 2; dy <= width/2; dy++ {
+			if dx*dx+dy*dy <= (width/2)*(width/2) {
+				if px+dx >= 0 && px+dx < lastImageWidth && py+dy >= 0 && py+dy < lastImageHeight {
+					img.Set(px+dx, py+dy, c)
+				}
+			}
+		}
+	}
+}
+
+// Draws circular points along the line between two points
+func drawSegment(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	drawLine(img, x0, y0, x1, y1, stroke.line, c)
+}
+
+// Draws a line between two points out of circles of the given width
+func drawLine(img *image.RGBA, x0, y0, x1, y1, width int, c color.Color) {
+	dx := x1 - x0
+	dy := y1 - y0
+	steps := int(math.Max(math.Abs(float64(dx)), math.Abs(float64(dy))))
+	if steps == 0 {
+		drawDisc(img, x0, y0, width, c)
+		return
+	}
+	for step := 0; step <= steps; step++ {
+		drawDisc(img, x0+dx*step/steps, y0+dy*step/steps, width, c)
+	}
+}
+
+// Draws a line through points given in graph coordinates, joining each point to the one before
+// unless the pen was lifted in between. Every segment is clipped to the view so a line leaving
+// the graph runs right up to its edge, and one coming back in starts from there.
+type pen struct {
+	img    *image.RGBA
+	color  color.Color
+	x, y   float64
+	placed bool // whether there is a point to join the next one to
+}
+
+func (p *pen) lift() {
+	p.placed = false
+}
+
+func (p *pen) to(x, y float64) {
+	if p.placed {
+		if x0, y0, x1, y1, visible := clipToView(p.x, p.y, x, y); visible {
+			px0, py0 := toImage(x0, y0)
+			px1, py1 := toImage(x1, y1)
+			drawSegment(p.img, px0, py0, px1, py1, p.color)
+		}
+	} else if outcode(x, y) == 0 {
+		px, py := toImage(x, y)
+		drawDot(p.img, px, py, p.color)
+	}
+	p.x, p.y, p.placed = x, y, true
+}
+
+// Image pixel a point of the graph lands on
+func toImage(x, y float64) (int, int) {
+	return int(mapRange(x, viewXMin, viewXMax, 0, float64(lastImageWidth-1))),
+		int(mapRange(y, viewYMin, viewYMax, float64(lastImageHeight-1), 0))
+}
+
+// Which sides of the view a point lies beyond, as Cohen-Sutherland outcode bits, 0 inside the view
+const (
+	outsideLeft = 1 << iota
+	outsideRight
+	outsideBelow
+	outsideAbove
+)
+
+func outcode(x, y float64) int {
+	code := 0
+	if x < viewXMin {
+		code |= outsideLeft
+	} else if x > viewXMax {
+		code |= outsideRight
+	}
+	if y < viewYMin {
+		code |= outsideBelow
+	} else if y > viewYMax {
+		code |= outsideAbove
+	}
+	return code
+}
+
+// Clips the segment between two points of the graph to the view by Cohen-Sutherland: an end outside
+// is moved along the segment onto the side it lies beyond until both ends are inside, or both lie
+// beyond the same side and nothing of the segment can be seen
+func clipToView(x0, y0, x1, y1 float64) (float64, float64, float64, float64, bool) {
+	code0, code1 := outcode(x0, y0), outcode(x1, y1)
+	// Each end needs at most two moves, one onto a side and one onto the corner, rounding aside
+	for moves := 0; moves < 8; moves++ {
+		switch {
+		case code0|code1 == 0:
+			return x0, y0, x1, y1, true
+		case code0&code1 != 0:
+			return 0, 0, 0, 0, false
+		}
+		code := code0
+		if code == 0 {
+			code = code1
+		}
+		var x, y float64
+		switch {
+		case code&outsideAbove != 0:
+			x, y = x0+(x1-x0)*(viewYMax-y0)/(y1-y0), viewYMax
+		case code&outsideBelow != 0:
+			x, y = x0+(x1-x0)*(viewYMin-y0)/(y1-y0), viewYMin
+		case code&outsideRight != 0:
+			x, y = viewXMax, y0+(y1-y0)*(viewXMax-x0)/(x1-x0)
+		default:
+			x, y = viewXMin, y0+(y1-y0)*(viewXMin-x0)/(x1-x0)
+		}
+		if math.IsNaN(x) || math.IsNaN(y) {
+			return 0, 0, 0, 0, false // ends too far out for the crossing to be worked out
+		}
+		if code == code0 {
+			x0, y0, code0 = x, y, outcode(x, y)
+		} else {
+			x1, y1, code1 = x, y, outcode(x, y)
+		}
+	}
+	return 0, 0, 0, 0, false
 }
 
 // Converts a tcell.Color to color.Color
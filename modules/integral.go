@@ -0,0 +1,192 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Nodes and weights of the 15 point Gauss-Kronrod rule on [-1, 1], only the non-negative half since
+// the rule is symmetric. Every other node is also a node of the 7 point Gauss rule, weighted by gaussWeights.
+var (
+	kronrodNodes = [8]float64{
+		0.991455371120812639206854697526329, 0.949107912342758524526189684047851,
+		0.864864423359769072789712788640926, 0.741531185599394439863864773280788,
+		0.586087235467691130294144845693013, 0.405845151377397166906606412076961,
+		0.207784955007898467600689403773245, 0,
+	}
+	kronrodWeights = [8]float64{
+		0.022935322010529224963732008058970, 0.063092092629978553290700663189204,
+		0.104790010322250183839876322541518, 0.140653259715525918745189590510238,
+		0.169004726639267902826583426598550, 0.190350578064785409913256402421014,
+		0.204432940075298892414161999234649, 0.209482141084727828012999174891714,
+	}
+	gaussWeights = [4]float64{
+		0.129484966168869693270611432679082, 0.279705391489276667901467771423780,
+		0.381830050505118944950369775488975, 0.417959183673469387755102040816327,
+	}
+)
+
+const (
+	integralTolerance  = 1e-10 // error allowed relative to the size of the integral
+	integralIntervals  = 2000  // pieces an integral may be split into before settling for the estimate it has
+	integralNarrowest  = 1e-15 // narrowest piece relative to the whole interval
+	integralDivergence = 1e-3  // error estimate, relative to the size of |f|, past which an integral is taken not to converge
+)
+
+// Integrates f from a to b by adaptive Gauss-Kronrod quadrature, halving the pieces whose 7 and
+// 15 point results disagree by more than their share of the tolerance. The estimate is the sum of
+// those disagreements over the final pieces, a pessimistic bound on the actual error.
+func integrate(f func(float64) (float64, error), a, b float64) (value, estimate float64, err error) {
+	if math.IsNaN(a) || math.IsNaN(b) || math.IsInf(a, 0) || math.IsInf(b, 0) {
+		return 0, 0, errors.New("integral bounds must be finite")
+	}
+	if a == b {
+		return 0, 0, nil
+	}
+	if a > b {
+		value, estimate, err = integrate(f, b, a)
+		return -value, estimate, err
+	}
+
+	whole, wholeError, err := gaussKronrod(f, a, b)
+	if err != nil {
+		return 0, 0, err
+	}
+	// The tolerance follows the size of |f| rather than of the integral, which may cancel out to nothing
+	magnitude, _, err := gaussKronrod(func(x float64) (float64, error) {
+		y, err := f(x)
+		return math.Abs(y), err
+	}, a, b)
+	if err != nil {
+		return 0, 0, err
+	}
+	tolerance := math.Max(integralTolerance*magnitude, 1e-300)
+	budget, width := integralIntervals, b-a
+
+	var refine func(a, b, value, estimate float64) (float64, float64, error)
+	refine = func(a, b, value, estimate float64) (float64, float64, error) {
+		m := (a + b) / 2
+		// Pieces stop being split once they are too narrow to matter, which keeps a singular endpoint
+		// like that of 1/sqrt(x) at 0 from being chased down to where the nodes land on it
+		if estimate <= tolerance*(b-a)/width || budget <= 0 || b-a < width*integralNarrowest {
+			return value, estimate, nil
+		}
+		budget--
+		left, leftError, err := gaussKronrod(f, a, m)
+		if err != nil {
+			return 0, 0, err
+		}
+		right, rightError, err := gaussKronrod(f, m, b)
+		if err != nil {
+			return 0, 0, err
+		}
+		left, leftError, err = refine(a, m, left, leftError)
+		if err != nil {
+			return 0, 0, err
+		}
+		right, rightError, err = refine(m, b, right, rightError)
+		if err != nil {
+			return 0, 0, err
+		}
+		return left + right, leftError + rightError, nil
+	}
+	value, estimate, err = refine(a, b, whole, wholeError)
+	if err == nil && estimate > integralDivergence*magnitude {
+		return 0, 0, errors.New("integral does not converge")
+	}
+	return value, estimate, err
+}
+
+// The 15 point Kronrod result on [a, b] and how far the embedded 7 point Gauss result is from it
+func gaussKronrod(f func(float64) (float64, error), a, b float64) (float64, float64, error) {
+	center, half := (a+b)/2, (b-a)/2
+	mid, err := f(center)
+	if err != nil {
+		return 0, 0, err
+	}
+	kronrod, gauss := kronrodWeights[7]*mid, gaussWeights[3]*mid
+	for i := 0; i < 7; i++ {
+		left, err := f(center - half*kronrodNodes[i])
+		if err != nil {
+			return 0, 0, err
+		}
+		right, err := f(center + half*kronrodNodes[i])
+		if err != nil {
+			return 0, 0, err
+		}
+		kronrod += kronrodWeights[i] * (left + right)
+		if i%2 == 1 {
+			gauss += gaussWeights[i/2] * (left + right)
+		}
+	}
+	return kronrod * half, math.Abs(kronrod-gauss) * half, nil
+}
+
+// Integrate integrates expr, a function of x compiled by CreateFunction, from a to b and
+// returns the value with an estimate of its error
+func (c *Calculator) Integrate(expr string, a, b float64) (float64, float64, error) {
+	f, err := c.CreateFunction(expr)
+	if err != nil {
+		return 0, 0, err
+	}
+	if f == nil {
+		return 0, 0, errors.New("cannot integrate a definition")
+	}
+	return integrate(f, a, b)
+}
+
+// Evaluates an integral node, its body is compiled the way CreateFunction compiles a function
+// of x with the rest of vars held fixed
+func (c *Calculator) evalIntegral(n *IntegralNode, vars map[string]float64) (float64, float64, error) {
+	a, err := c.eval(n.Lower, vars)
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := c.eval(n.Upper, vars)
+	if err != nil {
+		return 0, 0, err
+	}
+	value, estimate, err := integrate(c.integrand(n, vars), a, b)
+	if err != nil {
+		if _, positioned := err.(*ExpressionError); !positioned {
+			err = &ExpressionError{err.Error(), n.Position}
+		}
+		return 0, 0, err
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, 0, &ExpressionError{fmt.Sprintf("integral of %s diverges", n.Body), n.Position}
+	}
+	return value, estimate, nil
+}
+
+// Values of the integral of f from a up to each of the increasing points xs, worked out as running
+// sums outward from a on either side: every step only integrates from the point before. ok is false
+// where a value couldn't be had, and for every point beyond it on that side, whose integral from a
+// would have to cross the same bad spot.
+func cumulativeIntegral(f func(float64) (float64, error), a float64, xs []float64) (values []float64, ok []bool) {
+	values, ok = make([]float64, len(xs)), make([]bool, len(xs))
+	first := sort.SearchFloat64s(xs, a) // first point at or past a
+	outward := func(i, step int) {
+		from, sum := a, 0.0
+		for ; i >= 0 && i < len(xs); i += step {
+			value, _, err := integrate(f, from, xs[i])
+			sum += value
+			if err != nil || math.IsNaN(sum) || math.IsInf(sum, 0) {
+				return
+			}
+			values[i], ok[i], from = sum, true, xs[i]
+		}
+	}
+	outward(first, 1)
+	outward(first-1, -1)
+	return values, ok
+}
+
+// The body of an integral as a function of its variable
+func (c *Calculator) integrand(n *IntegralNode, vars map[string]float64) func(float64) (float64, error) {
+	return func(t float64) (float64, error) {
+		return c.evaluate(n.Body, withVariable(vars, n.Variable, t))
+	}
+}
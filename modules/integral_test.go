@@ -0,0 +1,79 @@
+package modules
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestIntegrate(t *testing.T) {
+	tests := []struct {
+		name, expr string
+		a, b, want float64
+		tolerance  float64
+	}{
+		{"smooth", "sin(x)", 0, math.Pi, 2, 1e-12},
+		{"bell curve", "exp(-x^2)", -10, 10, math.Sqrt(math.Pi), 1e-12},
+		{"reversed bounds", "x^2", 3, 0, -9, 1e-12},
+		{"singular endpoint", "1/sqrt(x)", 0, 1, 2, 1e-8},
+		{"logarithmic endpoint", "ln(x)", 0, 1, -1, 1e-10},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, estimate, err := NewCalculator().Integrate(test.expr, test.a, test.b)
+			if err != nil {
+				t.Fatalf("Integrate(%s, %g, %g): %v", test.expr, test.a, test.b, err)
+			}
+			if !closeTo(value, test.want, test.tolerance) {
+				t.Errorf("Integrate(%s, %g, %g) = %.15g, want %.15g", test.expr, test.a, test.b, value, test.want)
+			}
+			// The estimate is meant to be pessimistic, it should never claim less than the actual error
+			if actual := math.Abs(value - test.want); estimate < actual-1e-15 {
+				t.Errorf("Integrate(%s, %g, %g) estimated its error as %g, it is %g", test.expr, test.a, test.b, estimate, actual)
+			}
+		})
+	}
+}
+
+func TestIntegrateDivergent(t *testing.T) {
+	for _, expr := range []string{"1/x", "1/x^2"} {
+		if _, _, err := NewCalculator().Integrate(expr, 0, 1); err == nil || err.Error() != "integral does not converge" {
+			t.Errorf("Integrate(%s, 0, 1) = %v, want integral does not converge", expr, err)
+		}
+	}
+	// Inside an expression the error points at the integral
+	_, err := NewCalculator().CreateFunction("2 + int(1/t, t, 0, 1)")
+	if e, ok := err.(*ExpressionError); !ok || !strings.Contains(e.Message, "does not converge") || e.Position != 4 {
+		t.Errorf("2 + int(1/t, t, 0, 1) = %v, want integral does not converge at position 4", err)
+	}
+}
+
+func TestCumulativeIntegral(t *testing.T) {
+	square := func(x float64) (float64, error) { return x * x, nil }
+	xs := []float64{-2, -1, 0, 1, 2, 3}
+	values, ok := cumulativeIntegral(square, 0.5, xs)
+	for i, x := range xs {
+		if want := (x*x*x - 0.125) / 3; !ok[i] || !closeTo(values[i], want, 1e-12) {
+			t.Errorf("integral of x^2 from 0.5 to %g = %g, %v, want %g", x, values[i], ok[i], want)
+		}
+	}
+
+	// Past the pole at 0 nothing on that side can be had, the other side is unaffected
+	c := NewCalculator()
+	node, err := c.Parse("int(1/t, t, 1, x)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inverse := c.integrand(node.(*IntegralNode), nil)
+	xs = []float64{-2, -1, 0.5, 1, 2, 4}
+	values, ok = cumulativeIntegral(inverse, 1, xs)
+	for i, x := range xs {
+		if x < 0 {
+			if ok[i] {
+				t.Errorf("integral of 1/x from 1 to %g = %g, want none", x, values[i])
+			}
+		} else if !ok[i] || !closeTo(values[i], math.Log(x), 1e-10) {
+			t.Errorf("integral of 1/x from 1 to %g = %g, %v, want %g", x, values[i], ok[i], math.Log(x))
+		}
+	}
+}
@@ -60,12 +60,12 @@ func calculateIntersection() {
 		return
 	}
 
-	f1, err1 := CreateFunction(expr1)
+	f1, err1 := calc.CreateFunction(expr1)
 	if err1 != nil {
 		intersectResult.SetText("[red]f(x) Error: " + err1.Error())
 		return
 	}
-	f2, err2 := CreateFunction(expr2)
+	f2, err2 := calc.CreateFunction(expr2)
 	if err2 != nil {
 		intersectResult.SetText("[red]g(x) Error: " + err2.Error())
 		return
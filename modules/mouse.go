@@ -0,0 +1,126 @@
+package modules
+
+import (
+	"math"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// What dragging the mouse across the graph does
+type dragMode int
+
+const (
+	noDrag   dragMode = iota
+	panDrag           // left button, the graph follows the cursor
+	zoomDrag          // right button, the view is zoomed to the rectangle dragged out
+)
+
+// A mouse drag across the graph, from and to are screen cells
+type drag struct {
+	mode         dragMode
+	fromX, fromY int
+	toX, toY     int
+	xMin, xMax   float64 // the view when the drag started
+	yMin, yMax   float64
+}
+
+const wheelZoom = 0.8 // share of the view left after scrolling in once, scrolling out undoes it
+
+// Sets the text of the X/Y min/max fields to the view, installed by GraphTraversial
+var updateBoundsFields = func() {}
+
+// Moves the view to new bounds, keeping the bounds fields in step, bounds that are empty,
+// reversed or too narrow or wide to draw are ignored and false is returned
+func setView(xMin, xMax, yMin, yMax float64) bool {
+	for _, bounds := range [][2]float64{{xMin, xMax}, {yMin, yMax}} {
+		span := bounds[1] - bounds[0]
+		if math.IsNaN(span) || math.IsInf(span, 0) || span <= 1e-12*math.Max(1, math.Abs(bounds[0])) || span > 1e15 {
+			return false
+		}
+	}
+	viewXMin, viewXMax, viewYMin, viewYMax = xMin, xMax, yMin, yMax
+	updateBoundsFields()
+	RedrawGraph()
+	return true
+}
+
+// The point of the graph at the center of a screen cell
+func (g *graphView) cellToGraph(col, row int) (float64, float64) {
+	left, top, width, height := g.GetInnerRect()
+	return mapRange(float64(col-left)+0.5, 0, float64(width), viewXMin, viewXMax),
+		mapRange(float64(row-top)+0.5, 0, float64(height), viewYMax, viewYMin)
+}
+
+// Zooms with the scroll wheel about the point under the cursor, pans with the left button
+// and zooms to a rectangle dragged out with the right
+func (g *graphView) MouseHandler() func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+	return g.WrapMouseHandler(func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+		col, row := event.Position()
+		left, top, width, height := g.GetInnerRect()
+		inside := col >= left && col < left+width && row >= top && row < top+height
+		if !inside && g.drag.mode == noDrag {
+			return false, nil // a drag carries on outside the graph once started in it
+		}
+		switch action {
+		case tview.MouseScrollUp, tview.MouseScrollDown:
+			factor := wheelZoom
+			if action == tview.MouseScrollDown {
+				factor = 1 / wheelZoom
+			}
+			x, y := g.cellToGraph(col, row)
+			setView(x-(x-viewXMin)*factor, x+(viewXMax-x)*factor, y-(y-viewYMin)*factor, y+(viewYMax-y)*factor)
+			return true, nil
+		case tview.MouseLeftDown, tview.MouseRightDown:
+			g.drag = drag{mode: panDrag, fromX: col, fromY: row, toX: col, toY: row,
+				xMin: viewXMin, xMax: viewXMax, yMin: viewYMin, yMax: viewYMax}
+			if action == tview.MouseRightDown {
+				g.drag.mode = zoomDrag
+			}
+			return true, g
+		case tview.MouseMove:
+			if g.drag.mode == noDrag {
+				return false, nil
+			}
+			g.drag.toX, g.drag.toY = col, row
+			if g.drag.mode == panDrag {
+				d := g.drag
+				dx := float64(col-d.fromX) * (d.xMax - d.xMin) / float64(width)
+				dy := float64(row-d.fromY) * (d.yMax - d.yMin) / float64(height)
+				setView(d.xMin-dx, d.xMax-dx, d.yMin+dy, d.yMax+dy)
+			}
+			return true, g
+		case tview.MouseLeftUp, tview.MouseRightUp:
+			d := g.drag
+			g.drag.mode = noDrag
+			if d.mode == zoomDrag && col != d.fromX && row != d.fromY {
+				// The rectangle takes in every cell it was dragged across, up to the edges of the graph
+				x0, x1 := max(min(col, d.fromX), left)-left, min(max(col, d.fromX), left+width-1)-left+1
+				y0, y1 := max(min(row, d.fromY), top)-top, min(max(row, d.fromY), top+height-1)-top+1
+				setView(mapRange(float64(x0), 0, float64(width), viewXMin, viewXMax),
+					mapRange(float64(x1), 0, float64(width), viewXMin, viewXMax),
+					mapRange(float64(y1), 0, float64(height), viewYMax, viewYMin),
+					mapRange(float64(y0), 0, float64(height), viewYMax, viewYMin))
+			}
+			return d.mode != noDrag, nil
+		case tview.MouseLeftClick, tview.MouseRightClick:
+			return true, nil // the drag that made it has already been handled
+		}
+		return false, nil
+	})
+}
+
+// Shows the rectangle being dragged out for a zoom in reverse video
+func (g *graphView) drawSelection(screen tcell.Screen) {
+	d := g.drag
+	if d.mode != zoomDrag {
+		return
+	}
+	left, top, width, height := g.GetInnerRect()
+	for row := max(min(d.fromY, d.toY), top); row <= min(max(d.fromY, d.toY), top+height-1); row++ {
+		for col := max(min(d.fromX, d.toX), left); col <= min(max(d.fromX, d.toX), left+width-1); col++ {
+			char, combining, style, _ := screen.GetContent(col, row)
+			screen.SetContent(col, row, char, combining, style.Reverse(true))
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// A differential equation row such as y' = x - y, y(0) = 1, higher orders are written with more primes
+type odeProblem struct {
+	order   int       // highest derivative of y, also the number of initial conditions
+	slope   Node      // right side, in x, y and the derivatives of y below the order
+	x0      float64   // where the initial conditions are given
+	initial []float64 // y(x0), y'(x0), ... up to one derivative short of the order
+}
+
+// Coefficients of the Dormand-Prince method: the nodes, the matrix of stage weights, and the
+// weights of its fifth and embedded fourth order results, whose difference estimates the error
+var (
+	dormandNodes  = [7]float64{0, 1.0 / 5, 3.0 / 10, 4.0 / 5, 8.0 / 9, 1, 1}
+	dormandMatrix = [7][6]float64{
+		{},
+		{1.0 / 5},
+		{3.0 / 40, 9.0 / 40},
+		{44.0 / 45, -56.0 / 15, 32.0 / 9},
+		{19372.0 / 6561, -25360.0 / 2187, 64448.0 / 6561, -212.0 / 729},
+		{9017.0 / 3168, -355.0 / 33, 46732.0 / 5247, 49.0 / 176, -5103.0 / 18656},
+		{35.0 / 384, 0, 500.0 / 1113, 125.0 / 192, -2187.0 / 6784, 11.0 / 84},
+	}
+	dormandFifth  = [7]float64{35.0 / 384, 0, 500.0 / 1113, 125.0 / 192, -2187.0 / 6784, 11.0 / 84, 0}
+	dormandFourth = [7]float64{5179.0 / 57600, 0, 7571.0 / 16695, 393.0 / 640, -92097.0 / 339200, 187.0 / 2100, 1.0 / 40}
+)
+
+const (
+	odeTolerance = 1e-8   // error allowed in a step, relative to the size of the solution
+	odeSteps     = 100000 // most steps taken in either direction from the initial condition
+	odeSegments  = 400    // steps are never longer than the range solved over divided by this, so the plot stays smooth
+	odeBlowUp    = 1e12   // size past which a solution is taken to have run off to infinity
+)
+
+// Reports whether a row is a differential equation, which always starts with y'
+func isODE(text string) bool {
+	return strings.HasPrefix(strings.TrimSpace(text), "y'")
+}
+
+// y followed by k primes, the name of its kth derivative
+func primedY(k int) string {
+	return "y" + strings.Repeat("'", k)
+}
+
+// A piece of a row split at its top level commas, offset is where it starts in the row
+type rowPart struct {
+	text   string
+	offset int
+}
+
+func splitTopLevel(text string) []rowPart {
+	var parts []rowPart
+	depth, start := 0, 0
+	for i, r := range text {
+		switch r {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, rowPart{text[start:i], start})
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, rowPart{text[start:], start})
+}
+
+// Moves the position of an error found in part of a row to where that part sits in the row
+func offsetError(err error, offset int) error {
+	if e, ok := err.(*ExpressionError); ok {
+		return &ExpressionError{e.Message, e.Position + offset}
+	}
+	return err
+}
+
+// Parses a differential equation row, the equation comes first and one initial condition
+// for y and each of its derivatives below the order follows it
+func (c *Calculator) parseODE(text string) (*odeProblem, error) {
+	parts := splitTopLevel(text)
+	equation := parts[0]
+	eq := strings.Index(equation.text, "=")
+	if eq == -1 {
+		return nil, &ExpressionError{"a differential equation needs a right side, as in y' = x - y", equation.offset + len(equation.text)}
+	}
+	lhs := strings.TrimSpace(equation.text[:eq])
+	p := &odeProblem{order: strings.Count(lhs, "'")}
+	if lhs != primedY(p.order) {
+		return nil, &ExpressionError{"the left side must be y with primes, as in y' or y''", equation.offset + strings.Index(equation.text, lhs)}
+	}
+	variables := []string{"x"}
+	for k := 0; k < p.order; k++ {
+		variables = append(variables, primedY(k))
+	}
+	slope, err := c.parseWith(equation.text[eq+1:], variables)
+	if err != nil {
+		return nil, offsetError(err, equation.offset+eq+1)
+	}
+	p.slope = slope
+
+	if len(parts)-1 != p.order {
+		example := "y(0) = 1"
+		for k := 1; k < p.order; k++ {
+			example += fmt.Sprintf(", %s(0) = 0", primedY(k))
+		}
+		needs := "an initial condition"
+		if p.order > 1 {
+			needs = fmt.Sprintf("%d initial conditions", p.order)
+		}
+		return nil, &ExpressionError{fmt.Sprintf("a differential equation of order %d needs %s, as in %s", p.order, needs, example), len(text)}
+	}
+	p.initial = make([]float64, p.order)
+	given := make([]bool, p.order)
+	for i, part := range parts[1:] {
+		k, at, value, err := c.initialCondition(part)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case k >= p.order:
+			return nil, &ExpressionError{fmt.Sprintf("%s is not needed for an equation of order %d", primedY(k), p.order), part.offset}
+		case given[k]:
+			return nil, &ExpressionError{fmt.Sprintf("%s is given twice", primedY(k)), part.offset}
+		case i > 0 && at != p.x0:
+			return nil, &ExpressionError{"initial conditions must all be at the same x", part.offset}
+		}
+		p.x0, p.initial[k], given[k] = at, value, true
+	}
+	return p, nil
+}
+
+// Reads one initial condition, y(x0) = value or y'(x0) = value, k counts the primes
+func (c *Calculator) initialCondition(part rowPart) (k int, at, value float64, err error) {
+	eq := strings.Index(part.text, "=")
+	lhs := strings.TrimSpace(part.text[:max(eq, 0)])
+	open := strings.Index(lhs, "(")
+	if eq == -1 || open == -1 || !strings.HasSuffix(lhs, ")") || lhs[:open] != primedY(strings.Count(lhs[:open], "'")) {
+		return 0, 0, 0, &ExpressionError{"an initial condition reads y(x0) = value", part.offset + len(part.text) - len(strings.TrimLeft(part.text, " "))}
+	}
+	k = strings.Count(lhs[:open], "'")
+	start := part.offset + strings.Index(part.text, lhs)
+	if at, err = c.constantValue(lhs[open+1:len(lhs)-1], start+open+1); err != nil {
+		return 0, 0, 0, err
+	}
+	if value, err = c.constantValue(part.text[eq+1:], part.offset+eq+1); err != nil {
+		return 0, 0, 0, err
+	}
+	return k, at, value, nil
+}
+
+// Evaluates a constant expression found at offset in a row
+func (c *Calculator) constantValue(text string, offset int) (float64, error) {
+	node, err := c.parseWith(text, nil)
+	if err != nil {
+		return 0, offsetError(err, offset)
+	}
+	value, err := c.evaluate(node, nil)
+	return value, offsetError(err, offset)
+}
+
+// The derivatives of state = y, y', ... at x, the last one is the right side of the equation
+func (c *Calculator) derivatives(p *odeProblem, x float64, state []float64) ([]float64, error) {
+	vars := map[string]float64{"x": x}
+	for k, value := range state {
+		vars[primedY(k)] = value
+	}
+	top, err := c.evaluate(p.slope, vars)
+	if err != nil {
+		return nil, err
+	}
+	return append(slices.Clone(state[1:]), top), nil
+}
+
+// Takes one Dormand-Prince step of size h, returning the fifth order result and its error
+// relative to the tolerance, the step is good when that is at most 1
+func (c *Calculator) dormandPrince(p *odeProblem, x float64, state []float64, h float64) ([]float64, float64, error) {
+	var stages [7][]float64
+	for i := range stages {
+		point := slices.Clone(state)
+		for j := 0; j < i; j++ {
+			for n := range point {
+				point[n] += h * dormandMatrix[i][j] * stages[j][n]
+			}
+		}
+		var err error
+		if stages[i], err = c.derivatives(p, x+dormandNodes[i]*h, point); err != nil {
+			return nil, 0, err
+		}
+	}
+	next, size := slices.Clone(state), 0.0
+	for n := range next {
+		difference := 0.0
+		for i := range stages {
+			next[n] += h * dormandFifth[i] * stages[i][n]
+			difference += h * (dormandFifth[i] - dormandFourth[i]) * stages[i][n]
+		}
+		scale := odeTolerance * (1 + math.Max(math.Abs(state[n]), math.Abs(next[n])))
+		size = math.Max(size, math.Abs(difference)/scale)
+	}
+	if math.IsNaN(size) {
+		return nil, 0, errors.New("solution is undefined")
+	}
+	return next, size, nil
+}
+
+// Solves from the initial condition towards end, stopping early where the solution blows up or
+// the right side stops being defined. The first point returned is the initial condition.
+func (c *Calculator) solveTowards(p *odeProblem, end, longest float64) (xs, ys []float64) {
+	x, state := p.x0, slices.Clone(p.initial)
+	xs, ys = []float64{x}, []float64{state[0]}
+	direction := math.Copysign(1, end-x)
+	h := direction * longest
+	for steps := 0; direction*(end-x) > 0 && steps < odeSteps; steps++ {
+		if direction*(x+h-end) > 0 {
+			h = end - x
+		}
+		next, size, err := c.dormandPrince(p, x, state, h)
+		switch {
+		case err != nil:
+			h /= 2 // the step may have reached past where the right side is defined
+		case size <= 1:
+			x, state = x+h, next
+			if math.IsNaN(state[0]) || math.IsInf(state[0], 0) || math.Abs(state[0]) > odeBlowUp {
+				return xs, ys
+			}
+			xs, ys = append(xs, x), append(ys, state[0])
+			fallthrough
+		default:
+			h *= math.Min(5, math.Max(0.2, 0.9*math.Pow(size, -0.2)))
+		}
+		if math.Abs(h) > longest {
+			h = direction * longest
+		}
+		if math.Abs(h) < 1e-12*math.Max(1, math.Abs(x)) {
+			break // the steps have shrunk to nothing, the solution can't be continued past here
+		}
+	}
+	return xs, ys
+}
+
+// Solves a differential equation in both directions from its initial condition so the
+// solution covers from to to, returning its points in order of x
+func (c *Calculator) solveODE(p *odeProblem, from, to float64) (xs, ys []float64) {
+	longest := (to - from) / odeSegments
+	if from < p.x0 {
+		xs, ys = c.solveTowards(p, from, longest)
+		slices.Reverse(xs)
+		slices.Reverse(ys)
+	}
+	if to > p.x0 || xs == nil {
+		forwardX, forwardY := c.solveTowards(p, to, longest)
+		if xs != nil {
+			forwardX, forwardY = forwardX[1:], forwardY[1:] // the initial condition is already there
+		}
+		xs, ys = append(xs, forwardX...), append(ys, forwardY...)
+	}
+	return xs, ys
+}
+
+// SolveODE solves a differential equation written as a row, e.g. "y' = x - y, y(0) = 1",
+// from its initial condition out across from to to, returning points of the solution y(x)
+func (c *Calculator) SolveODE(equation string, from, to float64) ([]float64, []float64, error) {
+	if !isODE(equation) {
+		return nil, nil, errors.New("a differential equation starts with y', as in y' = x - y, y(0) = 1")
+	}
+	if !(from < to) {
+		return nil, nil, errors.New("the range to solve over must run from a smaller to a larger x")
+	}
+	p, err := c.parseODE(equation)
+	if err != nil {
+		return nil, nil, err
+	}
+	xs, ys := c.solveODE(p, from, to)
+	return xs, ys, nil
+}
@@ -0,0 +1,70 @@
+package modules
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// Solves equation across from to to and checks every point against the exact solution
+func checkODE(t *testing.T, equation string, from, to float64, exact func(x float64) float64) {
+	t.Helper()
+	xs, ys, err := NewCalculator().SolveODE(equation, from, to)
+	if err != nil {
+		t.Fatalf("SolveODE(%s): %v", equation, err)
+	}
+	if !slices.IsSorted(xs) {
+		t.Errorf("SolveODE(%s) returned points out of order", equation)
+	}
+	if xs[0] > from || xs[len(xs)-1] < to {
+		t.Errorf("SolveODE(%s) covers %g to %g, want at least %g to %g", equation, xs[0], xs[len(xs)-1], from, to)
+	}
+	for i, x := range xs {
+		if want := exact(x); !closeTo(ys[i], want, 1e-6) {
+			t.Errorf("SolveODE(%s) at %g = %.10g, want %.10g", equation, x, ys[i], want)
+			return
+		}
+	}
+}
+
+func TestSolveODE(t *testing.T) {
+	t.Run("exponential", func(t *testing.T) {
+		checkODE(t, "y' = y, y(0) = 1", -3, 3, math.Exp)
+	})
+	t.Run("oscillator", func(t *testing.T) {
+		checkODE(t, "y'' = -y, y(0) = 0, y'(0) = 1", -10, 10, math.Sin)
+	})
+	// With the initial condition past the end of the range everything is solved backwards from it
+	t.Run("start after the range", func(t *testing.T) {
+		checkODE(t, "y' = y, y(5) = e^5", 0, 2, math.Exp)
+	})
+	t.Run("start before the range", func(t *testing.T) {
+		checkODE(t, "y'' = -y, y(-5) = sin(-5), y'(-5) = cos(-5)", 0, 3, math.Sin)
+	})
+}
+
+// y' = y^2, y(0) = 1 is 1/(1 - x), which runs off to infinity at 1, the solution stops there rather
+// than carrying on across the pole. The last step or two may land a hair either side of it.
+func TestSolveODEBlowUp(t *testing.T) {
+	xs, ys, err := NewCalculator().SolveODE("y' = y^2, y(0) = 1", 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := xs[len(xs)-1]; math.Abs(last-1) > 1e-6 {
+		t.Errorf("solution of y' = y^2 stops at %g, want 1", last)
+	}
+	for i, x := range xs {
+		if want := 1 / (1 - x); x < 0.999 && !closeTo(ys[i], want, 1e-6) {
+			t.Errorf("solution of y' = y^2 at %g = %g, want %g", x, ys[i], want)
+			return
+		}
+	}
+}
+
+func TestSolveODEErrors(t *testing.T) {
+	for _, equation := range []string{"y = x", "y'' = -y, y(0) = 0", "y' = y, y(0) = 1, y'(0) = 1"} {
+		if _, _, err := NewCalculator().SolveODE(equation, 0, 1); err == nil {
+			t.Errorf("SolveODE(%s) should fail", equation)
+		}
+	}
+}
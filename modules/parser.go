@@ -0,0 +1,1077 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type ExpressionError struct {
+	Message  string
+	Position int
+}
+
+func (e *ExpressionError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Message, e.Position)
+}
+
+type TokenType int
+
+const (
+	NUMBER TokenType = iota
+	OPERATOR
+	FUNCTION
+	LPAREN
+	RPAREN
+	VARIABLE
+	CONSTANT
+	COMMA
+	LBRACE
+	RBRACE
+	COLON
+	UNIT
+	BANG // postfix factorial
+)
+
+type Token struct {
+	Type     TokenType
+	Value    string
+	Position int
+}
+
+// Operator precedence
+var precedence = map[string]int{
+	"+":  1,
+	"-":  1,
+	"*":  2,
+	"/":  2,
+	"^":  3,
+	"u-": 2, // unary minus has higher precedence than +,- and same as */
+}
+
+func validateExpression(expr string) error {
+	if len(strings.TrimSpace(expr)) == 0 {
+		return errors.New("empty expression")
+	}
+
+	// Check for unclosed parentheses
+	parenCount := 0
+	for i, char := range expr {
+		if char == '(' {
+			parenCount++
+		} else if char == ')' {
+			parenCount--
+			if parenCount < 0 {
+				return &ExpressionError{"unmatched closing parenthesis", i}
+			}
+		}
+	}
+	if parenCount > 0 {
+		return &ExpressionError{"unclosed parenthesis", len(expr) - 1}
+	}
+
+	// Check for consecutive operators
+	prevIsOp := true // treat start of expression as if it follows an operator
+	for i, char := range expr {
+		isOp := strings.ContainsRune("+-*/^", char)
+		if isOp && prevIsOp && char != '-' { // TODO: allow negative numbers
+			return &ExpressionError{"consecutive operators", i}
+		}
+		prevIsOp = isOp
+	}
+
+	// Check for trailing operator
+	lastChar := expr[len(expr)-1]
+	if strings.ContainsRune("+-*/^", rune(lastChar)) {
+		return &ExpressionError{"trailing operator", len(expr) - 1}
+	}
+
+	return nil
+}
+
+// Converts a string expression into tokens, identifiers listed in variables are read as variables
+func (c *Calculator) tokenize(expr string, variables []string) ([]Token, error) {
+	if err := validateExpression(expr); err != nil {
+		return nil, err
+	}
+
+	var tokens []Token
+	i := 0
+
+	// Skip leading whitespace
+	expr = strings.TrimSpace(expr)
+	scopes := bindingScopes(expr)
+
+	for i < len(expr) {
+		char := string(expr[i])
+		curPos := i
+
+		// Skip whitespace
+		if char == " " {
+			i++
+			continue
+		}
+
+		// Handle numbers
+		if (char >= "0" && char <= "9") || char == "." {
+			num := ""
+			dotCount := 0
+			startPos := i
+
+			// Read the entire number
+			for i < len(expr) && ((expr[i] >= '0' && expr[i] <= '9') || expr[i] == '.') {
+				if expr[i] == '.' {
+					dotCount++
+					if dotCount > 1 {
+						return nil, &ExpressionError{"multiple decimal points in number", i}
+					}
+				}
+				num += string(expr[i])
+				i++
+			}
+
+			if num == "." {
+				return nil, &ExpressionError{"invalid number format", curPos}
+			}
+
+			tokens = append(tokens, Token{Type: NUMBER, Value: num, Position: startPos})
+
+			// Skip any whitespace after the number
+			for i < len(expr) && expr[i] == ' ' {
+				i++
+			}
+
+			// Check if there's another number after whitespace
+			if i < len(expr) {
+				nextChar := expr[i]
+				if (nextChar >= '0' && nextChar <= '9') || nextChar == '.' {
+					return nil, &ExpressionError{"missing operator between numbers", i}
+				}
+			}
+			continue
+		}
+
+		// Handle comparisons, "<=", ">=" and "!=" are read as a single operator, so 3!=6 compares rather than
+		// being read as the equation 3! = 6
+		if char == "<" || char == ">" || (char == "!" && i+1 < len(expr) && expr[i+1] == '=') {
+			op := char
+			if i+1 < len(expr) && expr[i+1] == '=' {
+				op += "="
+			}
+			tokens = append(tokens, Token{Type: OPERATOR, Value: op, Position: curPos})
+			i += len(op)
+			continue
+		}
+
+		// Handle operators, '=' only separates the two sides of an equation
+		if char == "+" || char == "-" || char == "*" || char == "/" || char == "^" || char == "=" {
+			// Determine if '-' is unary based on previous token
+			if char == "-" {
+				prevIsStart := len(tokens) == 0
+				prev := Token{Type: OPERATOR}
+				if !prevIsStart {
+					prev = tokens[len(tokens)-1]
+				}
+				if prevIsStart || prev.Type == OPERATOR || prev.Type == LPAREN || prev.Type == COMMA || prev.Type == LBRACE || prev.Type == COLON {
+					// Unary minus
+					tokens = append(tokens, Token{Type: OPERATOR, Value: "u-", Position: curPos})
+					i++
+					continue
+				}
+			}
+			tokens = append(tokens, Token{Type: OPERATOR, Value: char, Position: curPos})
+			i++
+			continue
+		}
+
+		// Handle factorials, n!
+		if char == "!" {
+			tokens = append(tokens, Token{Type: BANG, Value: char, Position: curPos})
+			i++
+			continue
+		}
+
+		// Handle parentheses
+		if char == "(" {
+			tokens = append(tokens, Token{Type: LPAREN, Value: char, Position: curPos})
+			i++
+			continue
+		}
+		if char == ")" {
+			tokens = append(tokens, Token{Type: RPAREN, Value: char, Position: curPos})
+			i++
+			continue
+		}
+
+		// Handle argument separators
+		if char == "," {
+			tokens = append(tokens, Token{Type: COMMA, Value: char, Position: curPos})
+			i++
+			continue
+		}
+
+		// Handle piecewise braces, {condition: value, ...}
+		if char == "{" || char == "}" || char == ":" {
+			tokenType := map[string]TokenType{"{": LBRACE, "}": RBRACE, ":": COLON}[char]
+			tokens = append(tokens, Token{Type: tokenType, Value: char, Position: curPos})
+			i++
+			continue
+		}
+
+		// Handle variables, a lone x is always read on its own so 2xy style input keeps working
+		if char == "x" && (slices.Contains(variables, "x") || scopes.binds("x", i)) {
+			tokens = append(tokens, Token{Type: VARIABLE, Value: char, Position: curPos})
+			i++
+			continue
+		}
+
+		// Handle units with capitals in them (N, kN, Pa, MPa, kWh), every other name is lower case
+		if word := unitWord(expr[i:]); strings.ToLower(word) != word {
+			if _, exists := units[word]; !exists {
+				return nil, &ExpressionError{fmt.Sprintf("unknown unit: %s", word), curPos}
+			}
+			tokens = append(tokens, Token{Type: UNIT, Value: word, Position: curPos})
+			i += len(word)
+			continue
+		}
+
+		// Handle constants, functions and units
+		if char >= "a" && char <= "z" {
+			name := ""
+			startPos := i
+			// Special case for d/dx
+			if i+3 < len(expr) && expr[i:i+4] == "d/dx" {
+				name = "d/dx"
+				i += 4
+			} else {
+				// Regular identifier handling
+				for i < len(expr) && ((expr[i] >= 'a' && expr[i] <= 'z') || (expr[i] >= '0' && expr[i] <= '9')) {
+					name += string(expr[i])
+					i++
+				}
+			}
+
+			// Variables may carry primes, y' and y'' are variables of a differential equation row
+			if primes := len(expr[i:]) - len(strings.TrimLeft(expr[i:], "'")); primes > 0 && slices.Contains(variables, name+expr[i:i+primes]) {
+				i += primes
+				tokens = append(tokens, Token{Type: VARIABLE, Value: expr[startPos:i], Position: startPos})
+				continue
+			}
+
+			// Named parameters and variables bound by int(...) shadow everything else
+			if slices.Contains(variables, name) || scopes.binds(name, startPos) {
+				tokens = append(tokens, Token{Type: VARIABLE, Value: name, Position: startPos})
+				continue
+			}
+
+			// Columns of tables, t1.x and t1.y, read like constants until the parser sees them
+			if _, exists := c.tables[name]; exists {
+				suffix := columnSuffix(expr[i:])
+				if suffix == 0 {
+					return nil, &ExpressionError{fmt.Sprintf("%s is a table, use its columns %s.x and %s.y", name, name, name), startPos}
+				}
+				i += suffix
+				tokens = append(tokens, Token{Type: CONSTANT, Value: expr[startPos:i], Position: startPos})
+				continue
+			}
+
+			// "and" and "or" combine conditions
+			if name == "and" || name == "or" {
+				tokens = append(tokens, Token{Type: OPERATOR, Value: name, Position: startPos})
+				continue
+			}
+
+			// Check if it's a constant (built in or user defined), i is the imaginary unit in complex mode
+			if name == "i" && c.complexMode {
+				tokens = append(tokens, Token{Type: CONSTANT, Value: name, Position: startPos})
+				continue
+			}
+			if _, exists := mathConstants[name]; exists {
+				tokens = append(tokens, Token{Type: CONSTANT, Value: name, Position: startPos})
+				continue
+			}
+			if _, exists := c.constants[name]; exists {
+				tokens = append(tokens, Token{Type: CONSTANT, Value: name, Position: startPos})
+				continue
+			}
+			if _, exists := c.complexConstants[name]; exists {
+				tokens = append(tokens, Token{Type: CONSTANT, Value: name, Position: startPos})
+				continue
+			}
+
+			// Check if it's a function (built in or user defined), min is minutes unless it is called
+			_, isUnit := units[name]
+			if _, exists := mathFuncs[name]; exists && !(isUnit && !strings.HasPrefix(strings.TrimLeft(expr[i:], " "), "(")) {
+				tokens = append(tokens, Token{Type: FUNCTION, Value: name, Position: startPos})
+				continue
+			}
+			if _, exists := c.functions[name]; exists {
+				// Trailing primes refer to derivatives of the function: f'(x), f''(x)
+				for i < len(expr) && expr[i] == '\'' {
+					name += "'"
+					i++
+				}
+				tokens = append(tokens, Token{Type: FUNCTION, Value: name, Position: startPos})
+				continue
+			}
+
+			// Units come last, so a constant or parameter named m or s hides the unit
+			if isUnit {
+				tokens = append(tokens, Token{Type: UNIT, Value: name, Position: startPos})
+				continue
+			}
+
+			return nil, &ExpressionError{fmt.Sprintf("unknown identifier: %s", name), startPos}
+		}
+
+		return nil, &ExpressionError{"invalid character", i}
+	}
+
+	return tokens, nil
+}
+
+// A variable bound by a call such as int(t^2, t, 0, 1) in the text between its parentheses
+type scope struct {
+	name       string
+	start, end int
+}
+
+type scopes []scope
+
+// Reports whether name is a bound variable at position pos
+func (s scopes) binds(name string, pos int) bool {
+	for _, scope := range s {
+		if scope.name == name && pos > scope.start && pos < scope.end {
+			return true
+		}
+	}
+	return false
+}
+
+// Functions that bind a variable, with the argument that names it, integral(f, a, b) and integrate(f, a) always bind x
+var bindingFuncs = map[string]int{"int": 1, "sum": 0, "prod": 0}
+
+// Finds the variables bound by calls in expr ahead of tokenizing, so they read as variables
+// inside the call and keep any other meaning outside it
+func bindingScopes(expr string) scopes {
+	var found scopes
+	isName := func(b byte) bool { return (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') }
+	for i := 0; i < len(expr); i++ {
+		if expr[i] < 'a' || expr[i] > 'z' || (i > 0 && isName(expr[i-1])) {
+			continue
+		}
+		end := i
+		for end < len(expr) && isName(expr[end]) {
+			end++
+		}
+		word := expr[i:end]
+		open := end
+		for open < len(expr) && expr[open] == ' ' {
+			open++
+		}
+		index, binds := bindingFuncs[word]
+		if (!binds && !takesIntegrand(word)) || open >= len(expr) || expr[open] != '(' {
+			continue
+		}
+
+		// Split the arguments at the commas of this call, not those of calls nested in it
+		var args []string
+		depth, from, close := 0, open+1, len(expr)
+		for j := open; j < len(expr) && close == len(expr); j++ {
+			switch expr[j] {
+			case '(', '{':
+				depth++
+			case ')', '}':
+				depth--
+				if depth == 0 {
+					args = append(args, expr[from:j])
+					close = j
+				}
+			case ',':
+				if depth == 1 {
+					args = append(args, expr[from:j])
+					from = j + 1
+				}
+			}
+		}
+		name := "x"
+		if binds {
+			if index >= len(args) {
+				continue
+			}
+			name = strings.TrimSpace(args[index])
+		}
+		if isValidIdentifier(name) {
+			found = append(found, scope{name, open, close})
+		}
+	}
+	return found
+}
+
+// Reads the run of letters expr starts with
+func unitWord(expr string) string {
+	end := 0
+	for end < len(expr) && ((expr[end] >= 'a' && expr[end] <= 'z') || (expr[end] >= 'A' && expr[end] <= 'Z')) {
+		end++
+	}
+	return expr[:end]
+}
+
+func isValidIdentifier(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	// First character must be a letter
+	if name[0] < 'a' || name[0] > 'z' {
+		return false
+	}
+	// Rest can be letters or numbers
+	for i := 1; i < len(name); i++ {
+		if !((name[i] >= 'a' && name[i] <= 'z') || (name[i] >= '0' && name[i] <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+func validateTokenSequence(tokens []Token) error {
+	if len(tokens) == 0 {
+		return errors.New("empty token sequence")
+	}
+
+	for i := 0; i < len(tokens)-1; i++ {
+		curr := tokens[i]
+		next := tokens[i+1]
+
+		// Check for invalid sequences
+		switch curr.Type {
+		case COMMA, COLON:
+			if next.Type == COMMA || next.Type == COLON || next.Type == RPAREN || next.Type == RBRACE {
+				return &ExpressionError{fmt.Sprintf("missing value after '%s'", curr.Value), next.Position}
+			}
+			if next.Type == OPERATOR && next.Value != "u-" {
+				return &ExpressionError{fmt.Sprintf("operator after '%s'", curr.Value), next.Position}
+			}
+		case OPERATOR:
+			if next.Type == COMMA || next.Type == COLON {
+				return &ExpressionError{fmt.Sprintf("missing operand before '%s'", next.Value), next.Position}
+			}
+			// Allow unary minus to follow another operator
+			if next.Type == OPERATOR {
+				if next.Value == "u-" {
+					// Disallow consecutive unary minus  which is ambiguous in current shunting yard
+					if curr.Value == "u-" {
+						return &ExpressionError{"consecutive unary minus", next.Position}
+					}
+					continue
+				}
+				return &ExpressionError{"consecutive operators", next.Position}
+			}
+		case NUMBER:
+			if next.Type == NUMBER {
+				return &ExpressionError{"missing operator between numbers", next.Position}
+			}
+			// Allow implicit multiplication between number and variable/function/constant
+			if next.Type != OPERATOR && next.Type != RPAREN {
+				continue
+			}
+		case FUNCTION:
+			// integral(f, a, b) and integrate(f, a) take a function by name
+			isIntegrand := next.Type == COMMA && i >= 2 && tokens[i-1].Type == LPAREN && takesIntegrand(tokens[i-2].Value)
+			if next.Type != LPAREN && !isIntegrand {
+				return &ExpressionError{"missing opening parenthesis after function", next.Position}
+			}
+		case LPAREN:
+			if next.Type == RPAREN {
+				return &ExpressionError{"empty parentheses", next.Position}
+			}
+			if next.Type == COMMA {
+				return &ExpressionError{"missing argument before ','", next.Position}
+			}
+			if next.Type == OPERATOR && next.Value != "u-" {
+				return &ExpressionError{"operator after opening parenthesis", next.Position}
+			}
+		case LBRACE:
+			if next.Type == RBRACE {
+				return &ExpressionError{"empty braces", next.Position}
+			}
+			if next.Type == COMMA || next.Type == COLON {
+				return &ExpressionError{fmt.Sprintf("missing condition before '%s'", next.Value), next.Position}
+			}
+			if next.Type == OPERATOR && next.Value != "u-" {
+				return &ExpressionError{"operator after opening brace", next.Position}
+			}
+		case UNIT:
+			// Units multiply whatever follows them, like constants: 3 N m
+			if next.Type != OPERATOR && next.Type != RPAREN {
+				continue
+			}
+		case BANG:
+			if next.Type == LPAREN || next.Type == LBRACE || next.Type == FUNCTION || next.Type == VARIABLE || next.Type == CONSTANT || next.Type == NUMBER {
+				return &ExpressionError{"missing operator after '!'", next.Position}
+			}
+		case RPAREN:
+			if next.Type == LPAREN || next.Type == FUNCTION || next.Type == VARIABLE || next.Type == CONSTANT || next.Type == NUMBER {
+				return &ExpressionError{"missing operator after closing parenthesis", next.Position}
+			}
+		case RBRACE:
+			if next.Type == LPAREN || next.Type == LBRACE || next.Type == FUNCTION || next.Type == VARIABLE || next.Type == CONSTANT || next.Type == NUMBER {
+				return &ExpressionError{"missing operator after closing brace", next.Position}
+			}
+		case VARIABLE:
+			// Allow implicit multiplication between variable and number/function/constant
+			if next.Type != OPERATOR && next.Type != RPAREN {
+				continue
+			}
+		case CONSTANT:
+			// Allow implicit multiplication between constant and number/variable/function/constant
+			if next.Type != OPERATOR && next.Type != RPAREN {
+				continue
+			}
+		}
+	}
+
+	// Check first and last tokens
+	if len(tokens) > 0 {
+		first := tokens[0]
+		last := tokens[len(tokens)-1]
+
+		if first.Type == OPERATOR && first.Value != "-" && first.Value != "u-" {
+			return &ExpressionError{"expression cannot start with operator", first.Position}
+		}
+		if last.Type == OPERATOR {
+			return &ExpressionError{"expression cannot end with operator", last.Position}
+		}
+		if first.Type == BANG {
+			return &ExpressionError{"'!' needs a value before it", first.Position}
+		}
+		if first.Type == COMMA || last.Type == COMMA {
+			return &ExpressionError{"misplaced ','", first.Position}
+		}
+		if first.Type == COLON || last.Type == COLON {
+			return &ExpressionError{"misplaced ':'", first.Position}
+		}
+	}
+
+	return nil
+}
+
+// Parse turns expr into an expression tree, reporting the first problem found with its position
+func (c *Calculator) Parse(expr string) (Node, error) {
+	return c.parseWith(expr, []string{"x"})
+}
+
+// Parses expr with the given names bound as variables, e.g. the parameters of a function definition
+func (c *Calculator) parseWith(expr string, variables []string) (Node, error) {
+	tokens, err := c.tokenize(expr, variables)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTokenSequence(tokens); err != nil {
+		return nil, err
+	}
+
+	p := &parser{calc: c, tokens: tokens, end: len(expr)}
+	node, err := p.parseEquation()
+	if err != nil {
+		return nil, err
+	}
+	if tok, ok := p.peek(); ok {
+		return nil, &ExpressionError{fmt.Sprintf("unexpected '%s'", tok.Value), tok.Position}
+	}
+	return node, nil
+}
+
+// Recursive descent parser over an already validated token list
+type parser struct {
+	calc   *Calculator
+	tokens []Token
+	pos    int
+	end    int // length of the source text, used to position errors at the end of input
+}
+
+func (p *parser) peek() (Token, bool) {
+	if p.pos >= len(p.tokens) {
+		return Token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *parser) next() (Token, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return Token{}, &ExpressionError{"unexpected end of expression", p.end}
+	}
+	p.pos++
+	return tok, nil
+}
+
+// equation := condition ('=' condition)?
+func (p *parser) parseEquation() (Node, error) {
+	left, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+	tok, ok := p.peek()
+	if !ok || tok.Type != OPERATOR || tok.Value != "=" {
+		return left, nil
+	}
+	p.pos++
+	right, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+	return &EquationNode{Left: left, Right: right, Position: tok.Position}, nil
+}
+
+// condition := conjunction ('or' conjunction)*
+func (p *parser) parseCondition() (Node, error) {
+	return p.parseLogical("or", p.parseConjunction)
+}
+
+// conjunction := comparison ('and' comparison)*
+func (p *parser) parseConjunction() (Node, error) {
+	return p.parseLogical("and", p.parseComparison)
+}
+
+func (p *parser) parseLogical(op string, operand func() (Node, error)) (Node, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.Type != OPERATOR || tok.Value != op {
+			return left, nil
+		}
+		p.pos++
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &LogicalNode{Op: op, Left: left, Right: right, Position: tok.Position}
+	}
+}
+
+// comparison := expression (('<' | '<=' | '>' | '>=' | '!=') expression)*, a chain such as 0 < x < 2
+// reads as 0 < x and x < 2
+func (p *parser) parseComparison() (Node, error) {
+	left, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	var result Node
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.Type != OPERATOR || !isComparison(tok.Value) {
+			break
+		}
+		p.pos++
+		right, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		comparison := &ComparisonNode{Op: tok.Value, Left: left, Right: right, Position: tok.Position}
+		if result == nil {
+			result = comparison
+		} else {
+			result = &LogicalNode{Op: "and", Left: result, Right: comparison, Position: tok.Position}
+		}
+		left = right
+	}
+	if result == nil {
+		return left, nil
+	}
+	return result, nil
+}
+
+func isComparison(op string) bool {
+	return op == "<" || op == "<=" || op == ">" || op == ">=" || op == "!="
+}
+
+// expression := term (('+' | '-') term)*
+func (p *parser) parseExpression() (Node, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.Type != OPERATOR || (tok.Value != "+" && tok.Value != "-") {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryNode{Op: tok.Value, Left: left, Right: right, Position: tok.Position}
+	}
+}
+
+// term := unary (('*' | '/') unary | unary)*, where a missing operator is implicit multiplication
+func (p *parser) parseTerm() (Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		if !ok {
+			return left, nil
+		}
+		op := ""
+		switch {
+		case tok.Type == OPERATOR && (tok.Value == "*" || tok.Value == "/"):
+			op = tok.Value
+			p.pos++
+		case tok.Type == NUMBER || tok.Type == VARIABLE || tok.Type == CONSTANT || tok.Type == UNIT || tok.Type == FUNCTION || tok.Type == LPAREN || tok.Type == LBRACE:
+			op = "*"
+		default:
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryNode{Op: op, Left: left, Right: right, Position: tok.Position}
+	}
+}
+
+// unary := '-' unary | power
+func (p *parser) parseUnary() (Node, error) {
+	if tok, ok := p.peek(); ok && tok.Type == OPERATOR && tok.Value == "u-" {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryNode{Op: "-", Operand: operand, Position: tok.Position}, nil
+	}
+	return p.parsePower()
+}
+
+// power := postfix ('^' unary)?, so powers are right associative and bind tighter than negation
+func (p *parser) parsePower() (Node, error) {
+	base, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+	if tok, ok := p.peek(); ok && tok.Type == OPERATOR && tok.Value == "^" {
+		p.pos++
+		exponent, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryNode{Op: "^", Left: base, Right: exponent, Position: tok.Position}, nil
+	}
+	return base, nil
+}
+
+// postfix := primary '!'*, factorials bind tightest of all: 2^3! is 2^6 and -3! is -(3!)
+func (p *parser) parsePostfix() (Node, error) {
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for tok, ok := p.peek(); ok && tok.Type == BANG; tok, ok = p.peek() {
+		p.pos++
+		node = &CallNode{Name: "factorial", Args: []Node{node}, Position: tok.Position}
+	}
+	return node, nil
+}
+
+// primary := number | variable | constant | unit | function arguments | '(' condition (',' condition)* ')' | braces
+func (p *parser) parsePrimary() (Node, error) {
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch tok.Type {
+	case NUMBER:
+		val, err := strconv.ParseFloat(tok.Value, 64)
+		if err != nil {
+			return nil, &ExpressionError{"invalid number format", tok.Position}
+		}
+		return &NumberNode{Value: val, Literal: tok.Value, Position: tok.Position}, nil
+	case VARIABLE:
+		return &VariableNode{Name: tok.Value, Position: tok.Position}, nil
+	case CONSTANT:
+		if strings.Contains(tok.Value, ".") {
+			return nil, &ExpressionError{fmt.Sprintf("%s is a column of values, summarize it with mean(%s) or another statistic", tok.Value, tok.Value), tok.Position}
+		}
+		return &ConstantNode{Name: tok.Value, Position: tok.Position}, nil
+	case UNIT:
+		return &UnitNode{Name: tok.Value, Position: tok.Position}, nil
+	case FUNCTION:
+		if takesIntegrand(tok.Value) {
+			return p.parseIntegral(tok)
+		}
+		if _, ok := statistics[tok.Value]; ok {
+			return p.parseStatistic(tok)
+		}
+		args, err := p.parseArguments()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.calc.checkArity(tok.Value, len(args), tok.Position); err != nil {
+			return nil, err
+		}
+		if tok.Value == "d/dx" {
+			arg := args[0]
+			derivative := &DerivativeNode{Operand: arg, Position: tok.Position}
+			if d, err := differentiate(arg, "x"); err == nil {
+				derivative.Result = simplify(d)
+			}
+			return derivative, nil
+		}
+		if tok.Value == "piecewise" {
+			return newPiecewise(args, tok.Position)
+		}
+		if tok.Value == "int" {
+			variable, ok := args[1].(*VariableNode)
+			if !ok {
+				return nil, &ExpressionError{fmt.Sprintf("expected the variable to integrate over, got %s", args[1]), args[1].Pos()}
+			}
+			return &IntegralNode{Body: args[0], Variable: variable.Name, Lower: args[2], Upper: args[3], Position: tok.Position}, nil
+		}
+		if tok.Value == "sum" || tok.Value == "prod" {
+			index, ok := args[0].(*VariableNode)
+			if !ok {
+				return nil, &ExpressionError{fmt.Sprintf("expected the index to %s over, got %s", tok.Value, args[0]), args[0].Pos()}
+			}
+			return &SeriesNode{Op: tok.Value, Index: index.Name, From: args[1], To: args[2], Body: args[3], Position: tok.Position}, nil
+		}
+		return &CallNode{Name: tok.Value, Args: args, Position: tok.Position}, nil
+	case LPAREN:
+		inner, err := p.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		// A comma turns the group into a tuple: (x(t), y(t))
+		if next, ok := p.peek(); ok && next.Type == COMMA {
+			tuple := &TupleNode{Items: []Node{inner}, Position: tok.Position}
+			for next, ok := p.peek(); ok && next.Type == COMMA; next, ok = p.peek() {
+				p.pos++
+				item, err := p.parseCondition()
+				if err != nil {
+					return nil, err
+				}
+				tuple.Items = append(tuple.Items, item)
+			}
+			if _, err := p.expect(RPAREN, "unclosed parenthesis"); err != nil {
+				return nil, err
+			}
+			return tuple, nil
+		}
+		if _, err := p.expect(RPAREN, "unclosed parenthesis"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	case LBRACE:
+		return p.parseBraces(tok.Position)
+	}
+	return nil, &ExpressionError{fmt.Sprintf("unexpected '%s'", tok.Value), tok.Position}
+}
+
+// braces := '{' condition ':' condition (',' condition ':' condition)* (',' condition)? '}', the opening
+// brace already consumed, a last entry without a condition is the value when none of the conditions hold
+func (p *parser) parseBraces(pos int) (Node, error) {
+	var args []Node
+	for {
+		condition, err := p.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		if tok, ok := p.peek(); ok && tok.Type == COLON {
+			p.pos++
+			value, err := p.parseCondition()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, condition, value)
+		} else {
+			args = append(args, condition)
+			if tok, ok := p.peek(); ok && tok.Type == COMMA {
+				return nil, &ExpressionError{"only the last piece may leave out its condition", condition.Pos()}
+			}
+		}
+		if tok, ok := p.peek(); ok && tok.Type == COMMA {
+			p.pos++
+			continue
+		}
+		if _, err := p.expect(RBRACE, "unclosed brace"); err != nil {
+			return nil, err
+		}
+		return newPiecewise(args, pos)
+	}
+}
+
+// Reports whether name is integral or integrate, which take a function by name or an expression in x
+func takesIntegrand(name string) bool {
+	return name == "integral" || name == "integrate"
+}
+
+// statistic := name '(' column ')', the column of a table such as t1.y
+func (p *parser) parseStatistic(tok Token) (Node, error) {
+	usage := fmt.Sprintf("%s expects a column of a table: %s(t1.y)", tok.Value, tok.Value)
+	if _, err := p.expect(LPAREN, "missing opening parenthesis after function"); err != nil {
+		return nil, err
+	}
+	column, ok := p.peek()
+	if !ok {
+		return nil, &ExpressionError{usage, p.end}
+	}
+	if column.Type != CONSTANT || !strings.Contains(column.Value, ".") {
+		return nil, &ExpressionError{usage, column.Position}
+	}
+	p.pos++
+	if _, err := p.expect(RPAREN, usage); err != nil {
+		return nil, err
+	}
+	table, name, _ := strings.Cut(column.Value, ".")
+	return &StatNode{Func: tok.Value, Table: table, Column: name, Position: tok.Position}, nil
+}
+
+// integral := 'integral' '(' integrand ',' condition ',' condition ')' | 'integrate' '(' integrand ',' condition ')',
+// integrand := name | condition, with the name already consumed, a bare function name f stands for f(x).
+// integrate(f, a) is the integral from a up to x, a function of x.
+func (p *parser) parseIntegral(tok Token) (Node, error) {
+	usage := "integral expects 3 arguments: integral(f, a, b)"
+	bounds := make([]Node, 2)
+	if tok.Value == "integrate" {
+		usage = "integrate expects 2 arguments: integrate(f, a)"
+		bounds = bounds[:1]
+	}
+	if _, err := p.expect(LPAREN, "missing opening parenthesis after function"); err != nil {
+		return nil, err
+	}
+	var body Node
+	if tok, ok := p.peek(); ok && tok.Type == FUNCTION && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].Type == COMMA {
+		p.pos++
+		if err := p.calc.checkArity(tok.Value, 1, tok.Position); err != nil {
+			return nil, err
+		}
+		body = &CallNode{Name: tok.Value, Args: []Node{&VariableNode{Name: "x", Position: tok.Position}}, Position: tok.Position}
+	} else {
+		arg, err := p.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		body = arg
+	}
+	for i := range bounds {
+		if _, err := p.expect(COMMA, usage); err != nil {
+			return nil, err
+		}
+		bound, err := p.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		bounds[i] = bound
+	}
+	if _, err := p.expect(RPAREN, usage); err != nil {
+		return nil, err
+	}
+	if len(bounds) == 1 {
+		bounds = append(bounds, &VariableNode{Name: "x", Position: tok.Position})
+	}
+	return &IntegralNode{Body: body, Variable: "x", Lower: bounds[0], Upper: bounds[1], Position: tok.Position}, nil
+}
+
+// Builds a piecewise expression from alternating conditions and values, an odd count leaves a final
+// value used when no condition holds
+func newPiecewise(args []Node, pos int) (Node, error) {
+	node := &PiecewiseNode{Position: pos}
+	for i := 0; i+1 < len(args); i += 2 {
+		if !isCondition(args[i]) {
+			return nil, &ExpressionError{fmt.Sprintf("expected a condition such as x < 0, got %s", args[i]), args[i].Pos()}
+		}
+		if isCondition(args[i+1]) {
+			return nil, &ExpressionError{fmt.Sprintf("expected a value, got the condition %s", args[i+1]), args[i+1].Pos()}
+		}
+		node.Conditions = append(node.Conditions, args[i])
+		node.Values = append(node.Values, args[i+1])
+	}
+	if len(args)%2 == 1 {
+		node.Otherwise = args[len(args)-1]
+		if isCondition(node.Otherwise) {
+			return nil, &ExpressionError{fmt.Sprintf("expected a value, got the condition %s", node.Otherwise), node.Otherwise.Pos()}
+		}
+	}
+	return node, nil
+}
+
+// arguments := '(' condition (',' condition)* ')'
+func (p *parser) parseArguments() ([]Node, error) {
+	if _, err := p.expect(LPAREN, "missing opening parenthesis after function"); err != nil {
+		return nil, err
+	}
+	var args []Node
+	for {
+		arg, err := p.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if tok, ok := p.peek(); ok && tok.Type == COMMA {
+			p.pos++
+			continue
+		}
+		if _, err := p.expect(RPAREN, "unclosed parenthesis"); err != nil {
+			return nil, err
+		}
+		return args, nil
+	}
+}
+
+// Consumes the next token if it has the wanted type, otherwise reports message at its position
+func (p *parser) expect(want TokenType, message string) (Token, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return Token{}, &ExpressionError{message, p.end}
+	}
+	if tok.Type != want {
+		return Token{}, &ExpressionError{message, tok.Position}
+	}
+	p.pos++
+	return tok, nil
+}
+
+// Splits the left hand side of a definition into its name and parameter list,
+// "g(t, k)" gives g with parameters t and k, a bare "g" gives no parameters
+func parseSignature(lhs string) (string, []string, error) {
+	open := strings.Index(lhs, "(")
+	if open == -1 {
+		return strings.TrimSpace(lhs), nil, nil
+	}
+	name := strings.TrimSpace(lhs[:open])
+	if !strings.HasSuffix(strings.TrimRight(lhs, " "), ")") {
+		return "", nil, &ExpressionError{"missing ')' after parameters", len(lhs)}
+	}
+	inner := lhs[open+1 : strings.LastIndex(lhs, ")")]
+
+	var params []string
+	offset := open + 1
+	for _, part := range strings.Split(inner, ",") {
+		param := strings.TrimSpace(part)
+		pos := offset + strings.Index(part, param)
+		offset += len(part) + 1
+		if !isValidIdentifier(param) {
+			return "", nil, &ExpressionError{fmt.Sprintf("invalid parameter name: %q", param), pos}
+		}
+		if slices.Contains(params, param) {
+			return "", nil, &ExpressionError{fmt.Sprintf("duplicate parameter: %s", param), pos}
+		}
+		if _, exists := mathConstants[param]; exists {
+			return "", nil, &ExpressionError{fmt.Sprintf("cannot use built-in constant %s as a parameter", param), pos}
+		}
+		if _, exists := mathFuncs[param]; exists {
+			return "", nil, &ExpressionError{fmt.Sprintf("cannot use built-in function %s as a parameter", param), pos}
+		}
+		params = append(params, param)
+	}
+	return name, params, nil
+}
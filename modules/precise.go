@@ -0,0 +1,467 @@
+package modules
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// Built in constants worked out to any precision in bits
+var preciseConstants = map[string]func(prec uint) *big.Float{
+	"e":  eulerNumber,
+	"pi": machinPi,
+	"phi": func(prec uint) *big.Float {
+		five := new(big.Float).SetPrec(prec).SetInt64(5)
+		root := five.Sqrt(five)
+		return root.Quo(root.Add(root, big.NewFloat(1)), big.NewFloat(2))
+	},
+	"tau": func(prec uint) *big.Float {
+		pi := machinPi(prec)
+		return pi.Mul(pi, big.NewFloat(2))
+	},
+}
+
+// Bits worked out past the precision asked for, so the rounding in each step doesn't reach the result
+const constantGuardBits = 32
+
+// e as the sum of 1/k!, stopping once the terms drop below the last bit
+func eulerNumber(prec uint) *big.Float {
+	work := prec + constantGuardBits
+	sum := new(big.Float).SetPrec(work).SetInt64(1)
+	term := new(big.Float).SetPrec(work).SetInt64(1)
+	for k := int64(1); term.MantExp(nil)+int(work) > 0; k++ {
+		term.Quo(term, new(big.Float).SetInt64(k))
+		sum.Add(sum, term)
+	}
+	return sum.SetPrec(prec)
+}
+
+// pi by Machin's formula, pi = 16 arctan(1/5) - 4 arctan(1/239)
+func machinPi(prec uint) *big.Float {
+	work := prec + constantGuardBits
+	pi := arctanInverse(5, work)
+	pi.Mul(pi, big.NewFloat(16))
+	small := arctanInverse(239, work)
+	pi.Sub(pi, small.Mul(small, big.NewFloat(4)))
+	return pi.SetPrec(prec)
+}
+
+// arctan(1/n) by its series 1/n - 1/(3n³) + 1/(5n⁵) - ..., stopping once the terms drop below the last bit
+func arctanInverse(n int64, prec uint) *big.Float {
+	power := new(big.Float).SetPrec(prec).Quo(big.NewFloat(1), new(big.Float).SetInt64(n)) // 1/n^(2k+1)
+	nSquared := new(big.Float).SetInt64(n * n)
+	sum := new(big.Float).SetPrec(prec).Set(power)
+	for k := int64(1); power.MantExp(nil)+int(prec) > 0; k++ {
+		power.Quo(power, nSquared)
+		term := new(big.Float).SetPrec(prec).Quo(power, new(big.Float).SetInt64(2*k+1))
+		if k%2 == 1 {
+			sum.Sub(sum, term)
+		} else {
+			sum.Add(sum, term)
+		}
+	}
+	return sum
+}
+
+// Largest integer power worked out exactly, beyond it results switch to big.Float
+const maxExactPower = 4096
+
+// preciseValue is an exact fraction for as long as every step was exact, and a big.Float once something
+// irrational such as a square root came in. limited is set when a step could only be done in float64,
+// which caps the digits worth showing.
+type preciseValue struct {
+	rat     *big.Rat
+	float   *big.Float
+	limited bool
+}
+
+// Digits a float64 result can be trusted to
+const float64Digits = 15
+
+// PreciseString evaluates a constant expression exactly where possible and with digits significant
+// digits otherwise, fractions that don't end as decimals are written as such: "1/3". Units are
+// checked and written after the value as QuantityString does.
+func (c *Calculator) PreciseString(expr string, digits int) (string, error) {
+	node, err := c.Parse(expr)
+	if err != nil {
+		return "", err
+	}
+	d, err := c.dimensionOf(node, nil)
+	if err != nil {
+		return "", err
+	}
+	value, err := c.preciseResult(node, digits)
+	if err != nil {
+		return "", err
+	}
+	return withUnit(value.format(digits), d), nil
+}
+
+// Evaluates a whole constant expression precisely, its sums and products held to preciseSeriesTerms
+func (c *Calculator) preciseResult(node Node, digits int) (preciseValue, error) {
+	defer c.limitSeries(preciseSeriesTerms)()
+	return c.evalPrecise(node, nil, precisionFor(digits))
+}
+
+// Bits of big.Float precision for digits decimal digits, with some to spare for rounding along the way
+func precisionFor(digits int) uint {
+	return uint(math.Ceil(float64(digits)*math.Log2(10))) + 64
+}
+
+// Records the tree a user constant was evaluated from so precise evaluation can redo it exactly
+// instead of starting from the rounded float64
+func (c *Calculator) setConstantNode(name string, node Node) {
+	c.constantNodes[name] = node
+}
+
+func exact(r *big.Rat) preciseValue { return preciseValue{rat: r} }
+
+func (v preciseValue) toFloat(prec uint) *big.Float {
+	if v.rat != nil {
+		return new(big.Float).SetPrec(prec).SetRat(v.rat)
+	}
+	return new(big.Float).SetPrec(prec).Set(v.float)
+}
+
+func (v preciseValue) float64() float64 {
+	if v.rat != nil {
+		f, _ := v.rat.Float64()
+		return f
+	}
+	f, _ := v.float.Float64()
+	return f
+}
+
+func (v preciseValue) sign() int {
+	if v.rat != nil {
+		return v.rat.Sign()
+	}
+	return v.float.Sign()
+}
+
+// Value of a step that had to be done in float64
+func limitedValue(f float64, prec uint) preciseValue {
+	return preciseValue{float: new(big.Float).SetPrec(prec).SetFloat64(f), limited: true}
+}
+
+// evalPrecise walks the expression tree with exact fractions, falling back to big.Float and then float64
+func (c *Calculator) evalPrecise(node Node, vars map[string]preciseValue, prec uint) (preciseValue, error) {
+	switch n := node.(type) {
+	case *NumberNode:
+		r, ok := new(big.Rat).SetString(n.Literal)
+		if !ok {
+			r = new(big.Rat).SetFloat64(n.Value) // numbers made up by simplify have no source text
+		}
+		return exact(r), nil
+
+	case *VariableNode:
+		val, exists := vars[n.Name]
+		if !exists {
+			return preciseValue{}, &ExpressionError{fmt.Sprintf("unknown variable: %s", n.Name), n.Position}
+		}
+		return val, nil
+
+	case *ConstantNode:
+		if constant, exists := preciseConstants[n.Name]; exists {
+			return preciseValue{float: constant(prec)}, nil
+		}
+		if definition, exists := c.constantNodes[n.Name]; exists {
+			// Rows can be edited into constants defined in terms of each other, a = b after b = a,
+			// so the definition is set aside while it is worked out and a repeat uses the float64 value
+			delete(c.constantNodes, n.Name)
+			defer func() { c.constantNodes[n.Name] = definition }()
+			return c.evalPrecise(definition, nil, prec)
+		}
+		val, err := c.eval(n, nil)
+		if err != nil {
+			return preciseValue{}, err
+		}
+		return limitedValue(val, prec), nil
+
+	case *UnitNode:
+		return exact(units[n.Name].exact), nil
+
+	case *UnaryNode:
+		a, err := c.evalPrecise(n.Operand, vars, prec)
+		if err != nil {
+			return preciseValue{}, err
+		}
+		if a.rat != nil {
+			return exact(new(big.Rat).Neg(a.rat)), nil
+		}
+		return preciseValue{float: new(big.Float).Neg(a.float), limited: a.limited}, nil
+
+	case *BinaryNode:
+		a, err := c.evalPrecise(n.Left, vars, prec)
+		if err != nil {
+			return preciseValue{}, err
+		}
+		b, err := c.evalPrecise(n.Right, vars, prec)
+		if err != nil {
+			return preciseValue{}, err
+		}
+		return c.preciseBinary(n, a, b, prec)
+
+	case *CallNode:
+		return c.preciseCall(n, vars, prec)
+
+	case *SeriesNode:
+		return c.evalPreciseSeries(n, vars, prec)
+
+	case *DerivativeNode:
+		if n.Result != nil {
+			return c.evalPrecise(n.Result, vars, prec)
+		}
+
+	case *PiecewiseNode:
+		floatVars := make(map[string]float64, len(vars))
+		for name, value := range vars {
+			floatVars[name] = value.float64()
+		}
+		_, value, err := c.choosePiece(n, floatVars)
+		if err != nil {
+			return preciseValue{}, err
+		}
+		return c.evalPrecise(value, vars, prec)
+	}
+	return c.approximate(node, vars, prec)
+}
+
+// Evaluates node in float64, for the parts precise evaluation has no better way of doing
+func (c *Calculator) approximate(node Node, vars map[string]preciseValue, prec uint) (preciseValue, error) {
+	floatVars := make(map[string]float64, len(vars))
+	for name, value := range vars {
+		floatVars[name] = value.float64()
+	}
+	val, err := c.eval(node, floatVars)
+	if err != nil {
+		return preciseValue{}, err
+	}
+	return limitedValue(val, prec), nil
+}
+
+func (c *Calculator) preciseBinary(n *BinaryNode, a, b preciseValue, prec uint) (preciseValue, error) {
+	if n.Op == "/" && b.sign() == 0 {
+		return preciseValue{}, &ExpressionError{"division by zero", n.Position}
+	}
+	if a.rat != nil && b.rat != nil {
+		switch n.Op {
+		case "+":
+			return exact(new(big.Rat).Add(a.rat, b.rat)), nil
+		case "-":
+			return exact(new(big.Rat).Sub(a.rat, b.rat)), nil
+		case "*":
+			return exact(new(big.Rat).Mul(a.rat, b.rat)), nil
+		case "/":
+			return exact(new(big.Rat).Quo(a.rat, b.rat)), nil
+		case "^":
+			if power, ok := exactPower(a.rat, b.rat); ok {
+				return exact(power), nil
+			}
+			if a.rat.Sign() == 0 && b.rat.Sign() < 0 {
+				return preciseValue{}, &ExpressionError{"invalid power operation result", n.Position}
+			}
+		}
+	}
+
+	limited := a.limited || b.limited
+	x, y := a.toFloat(prec), b.toFloat(prec)
+	switch n.Op {
+	case "+":
+		return preciseValue{float: x.Add(x, y), limited: limited}, nil
+	case "-":
+		return preciseValue{float: x.Sub(x, y), limited: limited}, nil
+	case "*":
+		return preciseValue{float: x.Mul(x, y), limited: limited}, nil
+	case "/":
+		return preciseValue{float: x.Quo(x, y), limited: limited}, nil
+	case "^":
+		// Square roots stay precise, other fractional powers go through float64
+		if b.rat != nil && b.rat.Cmp(big.NewRat(1, 2)) == 0 && x.Sign() >= 0 {
+			return preciseValue{float: x.Sqrt(x), limited: limited}, nil
+		}
+		if y.IsInt() && new(big.Float).Abs(y).Cmp(big.NewFloat(maxExactPower)) <= 0 {
+			exponent, _ := y.Int64()
+			if x.Sign() == 0 && exponent < 0 {
+				return preciseValue{}, &ExpressionError{"invalid power operation result", n.Position}
+			}
+			return preciseValue{float: floatPower(x, exponent), limited: limited}, nil
+		}
+		result := math.Pow(a.float64(), b.float64())
+		if math.IsNaN(result) || math.IsInf(result, 0) {
+			return preciseValue{}, &ExpressionError{"invalid power operation result", n.Position}
+		}
+		return limitedValue(result, prec), nil
+	}
+	return preciseValue{}, &ExpressionError{fmt.Sprintf("unknown operator: %s", n.Op), n.Position}
+}
+
+// Raises a fraction to a whole power that isn't too large to write out
+func exactPower(base, exponent *big.Rat) (*big.Rat, bool) {
+	if !exponent.IsInt() || exponent.Num().CmpAbs(big.NewInt(maxExactPower)) > 0 {
+		return nil, false
+	}
+	n := exponent.Num().Int64()
+	if n < 0 && base.Sign() == 0 {
+		return nil, false
+	}
+	abs := big.NewInt(n)
+	abs.Abs(abs)
+	num := new(big.Int).Exp(base.Num(), abs, nil)
+	den := new(big.Int).Exp(base.Denom(), abs, nil)
+	if n < 0 {
+		num, den = den, num
+	}
+	return new(big.Rat).SetFrac(num, den), true
+}
+
+// x^n by repeated squaring
+func floatPower(x *big.Float, n int64) *big.Float {
+	result := new(big.Float).SetPrec(x.Prec()).SetInt64(1)
+	base := new(big.Float).Copy(x)
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			result.Mul(result, base)
+		}
+		base.Mul(base, base)
+	}
+	if negative {
+		result.Quo(new(big.Float).SetPrec(x.Prec()).SetInt64(1), result)
+	}
+	return result
+}
+
+func (c *Calculator) preciseCall(n *CallNode, vars map[string]preciseValue, prec uint) (preciseValue, error) {
+	args := make([]preciseValue, len(n.Args))
+	for i, arg := range n.Args {
+		val, err := c.evalPrecise(arg, vars, prec)
+		if err != nil {
+			return preciseValue{}, err
+		}
+		args[i] = val
+	}
+	if err := c.checkArity(n.Name, len(args), n.Position); err != nil {
+		return preciseValue{}, err
+	}
+
+	if f, exists := c.lookupFunction(n.Name); exists && f.body != nil {
+		scoped := make(map[string]preciseValue, len(f.params))
+		for i, param := range f.params {
+			scoped[param] = args[i]
+		}
+		return c.evalPrecise(f.body, scoped, prec)
+	}
+
+	a := args[0]
+	switch n.Name {
+	case "sqrt":
+		if a.sign() < 0 {
+			return preciseValue{}, &ExpressionError{fmt.Sprintf("domain error: sqrt(%f) - square root of negative number", a.float64()), n.Position}
+		}
+		// Perfect squares stay exact: sqrt(9/4) = 3/2
+		if a.rat != nil {
+			num, den := new(big.Int).Sqrt(a.rat.Num()), new(big.Int).Sqrt(a.rat.Denom())
+			if root := new(big.Rat).SetFrac(num, den); new(big.Rat).Mul(root, root).Cmp(a.rat) == 0 {
+				return exact(root), nil
+			}
+		}
+		x := a.toFloat(prec)
+		return preciseValue{float: x.Sqrt(x), limited: a.limited}, nil
+	case "abs":
+		if a.rat != nil {
+			return exact(new(big.Rat).Abs(a.rat)), nil
+		}
+		return preciseValue{float: new(big.Float).Abs(a.float), limited: a.limited}, nil
+	case "floor", "ceil", "round":
+		if a.rat != nil {
+			return exact(new(big.Rat).SetInt(roundRat(a.rat, n.Name))), nil
+		}
+	case "factorial":
+		// Whole numbers are multiplied out exactly: 25! = 15511210043330985984000000
+		if a.rat != nil && a.rat.IsInt() && a.rat.Sign() >= 0 && a.rat.Num().IsInt64() && a.rat.Num().Int64() <= maxExactPower {
+			return exact(new(big.Rat).SetInt(new(big.Int).MulRange(1, a.rat.Num().Int64()))), nil
+		}
+	}
+
+	// Everything else is worked out in float64
+	floatArgs := make([]float64, len(args))
+	for i, arg := range args {
+		floatArgs[i] = arg.float64()
+	}
+	var result float64
+	var err error
+	if f, exists := c.lookupFunction(n.Name); exists {
+		result, err = f.fn(floatArgs)
+	} else if builtin, exists := mathFuncs[n.Name]; exists && builtin.call != nil {
+		result, err = builtin.call(floatArgs)
+	} else {
+		return preciseValue{}, &ExpressionError{fmt.Sprintf("unknown function: %s", n.Name), n.Position}
+	}
+	if err != nil {
+		return preciseValue{}, &ExpressionError{err.Error(), n.Position}
+	}
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return preciseValue{}, &ExpressionError{fmt.Sprintf("domain error: %s(%s) produced an invalid result", n.Name, formatArgs(floatArgs)), n.Position}
+	}
+	return limitedValue(result, prec), nil
+}
+
+// floor, ceil or round (half away from zero) of a fraction
+func roundRat(r *big.Rat, mode string) *big.Int {
+	if mode == "round" {
+		half := big.NewRat(1, 2)
+		if r.Sign() < 0 {
+			half.Neg(half)
+		}
+		shifted := new(big.Rat).Add(r, half)
+		return new(big.Int).Quo(shifted.Num(), shifted.Denom()) // truncates toward zero
+	}
+	quotient, remainder := new(big.Int).DivMod(r.Num(), r.Denom(), new(big.Int)) // floors, the denominator is positive
+	if mode == "ceil" && remainder.Sign() != 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+	return quotient
+}
+
+// Formats a value with up to digits significant digits. Fractions whose decimals end are written out in
+// full when they fit, other fractions are written as p/q.
+func (v preciseValue) format(digits int) string {
+	if v.rat != nil {
+		if v.rat.IsInt() {
+			return v.rat.Num().String()
+		}
+		if decimals, ok := terminatingDecimals(v.rat.Denom()); ok && decimals <= digits {
+			return v.rat.FloatString(decimals)
+		}
+		return v.rat.String()
+	}
+	if v.limited {
+		digits = min(digits, float64Digits)
+	}
+	return v.float.Text('g', digits)
+}
+
+// Reports how many decimals a fraction with this denominator has when it ends at all,
+// which it does when the denominator has no prime factors but 2 and 5
+func terminatingDecimals(den *big.Int) (int, bool) {
+	rest := new(big.Int).Set(den)
+	twos, fives := 0, 0
+	zero, two, five := big.NewInt(0), big.NewInt(2), big.NewInt(5)
+	remainder := new(big.Int)
+	for {
+		if quotient, _ := new(big.Int).QuoRem(rest, two, remainder); remainder.Cmp(zero) == 0 {
+			rest, twos = quotient, twos+1
+			continue
+		}
+		if quotient, _ := new(big.Int).QuoRem(rest, five, remainder); remainder.Cmp(zero) == 0 {
+			rest, fives = quotient, fives+1
+			continue
+		}
+		break
+	}
+	return max(twos, fives), rest.IsInt64() && rest.Int64() == 1
+}
@@ -0,0 +1,370 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// A regression row such as t1.y ~ a*x^2 + b*x + c, the names the model leaves undefined are
+// the constants fitted to the points of the table, x stands for the table's x column
+type regression struct {
+	model    Node
+	params   []string  // the fitted constants, in the order they first appear
+	values   []float64 // their fitted values
+	rSquared float64   // share of the variance in y the fit explains, NaN when y doesn't vary
+	key      string    // everything the fit was worked out from, see fitKey
+}
+
+const (
+	fitIterations = 200   // most Levenberg-Marquardt steps taken before settling for the fit found
+	fitTolerance  = 1e-12 // relative change in the sum of squares below which a fit has converged
+)
+
+// Reports whether a row is a regression, which has a ~ between its table and its model
+func isRegression(text string) bool {
+	return strings.Contains(text, "~")
+}
+
+// Names of the constants a model leaves undefined, x, bound variables and anything the calculator
+// knows other than units are excluded, so m*x + b fits m instead of reading it as metres
+func (c *Calculator) freeNames(model string) []string {
+	var names []string
+	scopes := bindingScopes(model)
+	isName := func(b byte) bool { return (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') }
+	for i := 0; i < len(model); {
+		switch {
+		case (model[i] >= '0' && model[i] <= '9') || model[i] == '.':
+			for i < len(model) && ((model[i] >= '0' && model[i] <= '9') || model[i] == '.') {
+				i++
+			}
+			continue
+		case model[i] < 'a' || model[i] > 'z':
+			i++
+			continue
+		case strings.HasPrefix(model[i:], "d/dx"):
+			i += 4
+			continue
+		}
+		end := i
+		for end < len(model) && isName(model[end]) {
+			end++
+		}
+		name := model[i:end]
+		i = end
+		if _, table := c.tables[name]; table {
+			i += columnSuffix(model[i:])
+			continue
+		}
+		_, constant := mathConstants[name]
+		_, defined := c.constants[name]
+		_, complexConstant := c.complexConstants[name]
+		_, builtin := mathFuncs[name]
+		_, function := c.functions[name]
+		called := strings.HasPrefix(strings.TrimLeft(model[i:], " "), "(")
+		bound := slices.ContainsFunc(scopes, func(s scope) bool { return s.name == name })
+		if name == "x" || name == "and" || name == "or" || (name == "i" && c.complexMode) || constant || defined ||
+			complexConstant || (builtin && called) || function || bound || slices.Contains(names, name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// Parses a regression row and fits it to its table, previous is the row's last fit, which is
+// returned as it is when neither the row nor anything its model uses has changed since
+func (c *Calculator) parseRegression(text string, previous *regression) (*regression, error) {
+	tilde := strings.Index(text, "~")
+	lhs := strings.TrimSpace(text[:tilde])
+	name, column, _ := strings.Cut(lhs, ".")
+	table, exists := c.tables[name]
+	switch {
+	case lhs == "":
+		return nil, &ExpressionError{"a regression needs a table to fit, as in t1.y ~ a*x + b", tilde}
+	case !exists:
+		return nil, &ExpressionError{fmt.Sprintf("unknown table: %s", name), strings.Index(text, lhs)}
+	case column != "" && column != "y":
+		return nil, &ExpressionError{fmt.Sprintf("a regression fits the y column, %s.y", name), strings.Index(text, lhs)}
+	}
+
+	r := &regression{params: c.freeNames(text[tilde+1:])}
+	if len(r.params) == 0 {
+		return nil, &ExpressionError{"a regression needs a constant to fit, as in a*x + b", tilde + 1}
+	}
+	if len(table.xs) < len(r.params) {
+		return nil, &ExpressionError{fmt.Sprintf("fitting %d constants needs at least %d points, %s has %d", len(r.params), len(r.params), name, len(table.xs)), tilde}
+	}
+	model, err := c.parseWith(text[tilde+1:], append([]string{"x"}, r.params...))
+	if err != nil {
+		return nil, offsetError(err, tilde+1)
+	}
+	r.model, r.key = model, c.fitKey(text, r.params, model, table)
+	if previous != nil && previous.key == r.key {
+		return previous, nil
+	}
+	if r.values, err = c.fit(model, r.params, table.xs, table.ys); err != nil {
+		return nil, &ExpressionError{err.Error(), tilde}
+	}
+	r.rSquared = c.rSquared(r, table.xs, table.ys)
+	return r, nil
+}
+
+// Identifies what a fit depends on: the row, the constants fitted, its table's points, and the values of the constants,
+// bodies of the functions and points of the tables its model uses, following functions into the
+// functions they call in turn
+func (c *Calculator) fitKey(text string, params []string, model Node, table dataTable) string {
+	var key strings.Builder
+	fmt.Fprint(&key, text, params, c.complexMode, table.xs, table.ys)
+	names := referencedNames(model)
+	for i := 0; i < len(names); i++ {
+		name := names[i]
+		if value, ok := c.constants[name]; ok {
+			fmt.Fprintf(&key, "|%s=%v", name, value)
+		}
+		if value, ok := c.complexConstants[name]; ok {
+			fmt.Fprintf(&key, "|%s=%v", name, value)
+		}
+		if t, ok := c.tables[name]; ok {
+			fmt.Fprintf(&key, "|%s=%v%v", name, t.xs, t.ys)
+		}
+		if f, ok := c.functions[name]; ok && f.body != nil {
+			fmt.Fprintf(&key, "|%s(%s)=%s", name, strings.Join(f.params, ","), f.body)
+			for _, used := range referencedNames(f.body) {
+				if !slices.Contains(names, used) {
+					names = append(names, used)
+				}
+			}
+		}
+	}
+	return key.String()
+}
+
+// Value of a fitted model at x
+func (c *Calculator) predict(model Node, params []string, values []float64, x float64) (float64, error) {
+	vars := map[string]float64{"x": x}
+	for i, param := range params {
+		vars[param] = values[i]
+	}
+	return c.evaluate(model, vars)
+}
+
+// Fits the parameters of model to the points by least squares. A model linear in its parameters is
+// solved in a single step from zero, any other is improved step by step from one by Levenberg-Marquardt.
+func (c *Calculator) fit(model Node, params []string, xs, ys []float64) ([]float64, error) {
+	// The partial derivatives in each parameter, nil where they can't be had symbolically
+	partials := make([]Node, len(params))
+	linear := true
+	for i, param := range params {
+		if d, err := differentiate(model, param); err == nil {
+			partials[i] = simplify(d)
+		}
+		linear = linear && partials[i] != nil && !slices.ContainsFunc(params, func(p string) bool { return usesVariable(partials[i], p) })
+	}
+
+	residuals := func(values []float64) ([]float64, float64, error) {
+		r, squares := make([]float64, len(xs)), 0.0
+		for i, x := range xs {
+			y, err := c.predict(model, params, values, x)
+			if err != nil {
+				return nil, 0, err
+			}
+			r[i] = ys[i] - y
+			squares += r[i] * r[i]
+		}
+		if math.IsNaN(squares) || math.IsInf(squares, 0) {
+			return nil, 0, errors.New("the model is undefined at some of the points")
+		}
+		return r, squares, nil
+	}
+	jacobian := func(values []float64) ([][]float64, error) {
+		rows := make([][]float64, len(xs))
+		for i, x := range xs {
+			rows[i] = make([]float64, len(params))
+			vars := map[string]float64{"x": x}
+			for j, param := range params {
+				vars[param] = values[j]
+			}
+			for j, param := range params {
+				var err error
+				if partials[j] != nil {
+					rows[i][j], err = c.evaluate(partials[j], vars)
+				} else {
+					rows[i][j], err = c.partialDifference(model, vars, param)
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		return rows, nil
+	}
+
+	values := make([]float64, len(params))
+	if !linear {
+		for i := range values {
+			values[i] = 1
+		}
+	}
+	r, squares, err := residuals(values)
+	if err != nil {
+		return nil, err
+	}
+	lambda := 1e-3
+	if linear {
+		lambda = 0
+	}
+	for iteration := 0; iteration < fitIterations; iteration++ {
+		j, err := jacobian(values)
+		if err != nil {
+			return nil, err
+		}
+		// The normal equations (JᵀJ + λ diag(JᵀJ)) δ = Jᵀr
+		normal, gradient := make([][]float64, len(params)), make([]float64, len(params))
+		for a := range params {
+			normal[a] = make([]float64, len(params))
+			for b := range params {
+				for i := range xs {
+					normal[a][b] += j[i][a] * j[i][b]
+				}
+			}
+			for i := range xs {
+				gradient[a] += j[i][a] * r[i]
+			}
+		}
+		improved := false
+		for !improved && lambda <= 1e10 {
+			damped := make([][]float64, len(params))
+			for a := range normal {
+				damped[a] = slices.Clone(normal[a])
+				damped[a][a] += lambda * normal[a][a]
+			}
+			step, err := solveLinear(damped, gradient)
+			if err != nil {
+				if linear {
+					return nil, errors.New("the points don't pin down every constant")
+				}
+				lambda *= 10
+				continue
+			}
+			trial := slices.Clone(values)
+			for a := range trial {
+				trial[a] += step[a]
+			}
+			trialR, trialSquares, err := residuals(trial)
+			if linear {
+				if err != nil {
+					return nil, err
+				}
+				return trial, nil
+			}
+			if err != nil || trialSquares >= squares {
+				lambda *= 10
+				continue
+			}
+			improved, lambda = true, math.Max(lambda/10, 1e-12)
+			converged := squares-trialSquares <= fitTolerance*squares
+			values, r, squares = trial, trialR, trialSquares
+			if converged {
+				return values, nil
+			}
+		}
+		if !improved {
+			break // no step makes the fit any better, it is as good as it gets from here
+		}
+	}
+	return values, nil
+}
+
+// Central difference approximation of the partial derivative of model in param
+func (c *Calculator) partialDifference(model Node, vars map[string]float64, param string) (float64, error) {
+	h := 1e-6 * math.Max(1, math.Abs(vars[param]))
+	above, err := c.evaluate(model, withVariable(vars, param, vars[param]+h))
+	if err != nil {
+		return 0, err
+	}
+	below, err := c.evaluate(model, withVariable(vars, param, vars[param]-h))
+	if err != nil {
+		return 0, err
+	}
+	return (above - below) / (2 * h), nil
+}
+
+// Share of the variance of the points about their mean that the fit explains
+func (c *Calculator) rSquared(r *regression, xs, ys []float64) float64 {
+	average, _ := mean(ys)
+	residual, spread := 0.0, 0.0
+	for i, x := range xs {
+		y, err := c.predict(r.model, r.params, r.values, x)
+		if err != nil {
+			return math.NaN()
+		}
+		residual += (ys[i] - y) * (ys[i] - y)
+		spread += (ys[i] - average) * (ys[i] - average)
+	}
+	if spread == 0 {
+		return math.NaN()
+	}
+	return 1 - residual/spread
+}
+
+// Solves a x = b by Gaussian elimination with partial pivoting, a and b are left as they were
+func solveLinear(a [][]float64, b []float64) ([]float64, error) {
+	n := len(b)
+	m := make([][]float64, n)
+	for i := range a {
+		m[i] = append(slices.Clone(a[i]), b[i])
+	}
+	scale := 0.0
+	for i := range m {
+		for _, value := range m[i][:n] {
+			scale = math.Max(scale, math.Abs(value))
+		}
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(m[pivot][col]) <= 1e-13*scale || scale == 0 {
+			return nil, errors.New("singular system")
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		for row := col + 1; row < n; row++ {
+			factor := m[row][col] / m[col][col]
+			for k := col; k <= n; k++ {
+				m[row][k] -= factor * m[col][k]
+			}
+		}
+	}
+	x := make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		sum := m[row][n]
+		for k := row + 1; k < n; k++ {
+			sum -= m[row][k] * x[k]
+		}
+		x[row] = sum / m[row][row]
+	}
+	return x, nil
+}
+
+// Fit fits a regression written as a row, "t1.y ~ a*x^2 + b*x + c", to a table set with SetTable,
+// returning the fitted constants by name and R²
+func (c *Calculator) Fit(text string) (map[string]float64, float64, error) {
+	if !isRegression(text) {
+		return nil, 0, errors.New("a regression is written table.y ~ model, as in t1.y ~ a*x + b")
+	}
+	r, err := c.parseRegression(text, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	fitted := make(map[string]float64, len(r.params))
+	for i, param := range r.params {
+		fitted[param] = r.values[i]
+	}
+	return fitted, r.rSquared, nil
+}
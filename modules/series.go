@@ -0,0 +1,137 @@
+package modules
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// Most terms a sum or product may have, so a typo in a bound can't hang the calculator. A plotted row
+// is worked out at every sample and again whenever a slider moves, so it gets far fewer, and so does
+// a precise one, whose exact fractions grow with every term.
+const (
+	maxSeriesTerms     = 1000000
+	plotSeriesTerms    = 5000
+	preciseSeriesTerms = 1000
+)
+
+// Lowers the terms a sum or product may have until the returned function is called
+func (c *Calculator) limitSeries(terms int64) (restore func()) {
+	saved := c.seriesTerms
+	c.seriesTerms = min(saved, terms)
+	return func() { c.seriesTerms = saved }
+}
+
+// Works out the whole number range of a sum or product, bound evaluates its bounds in the caller's mode
+func (c *Calculator) seriesRange(n *SeriesNode, bound func(Node) (float64, error)) (int64, int64, error) {
+	var ends [2]int64
+	for i, node := range [2]Node{n.From, n.To} {
+		value, err := bound(node)
+		if err != nil {
+			return 0, 0, err
+		}
+		if value != math.Trunc(value) || math.Abs(value) > 1e15 {
+			return 0, 0, &ExpressionError{fmt.Sprintf("%s bounds must be whole numbers, got %g", n.Op, value), node.Pos()}
+		}
+		ends[i] = int64(value)
+	}
+	if ends[1]-ends[0] >= c.seriesTerms {
+		return 0, 0, &ExpressionError{fmt.Sprintf("%s has more than %d terms", n.Op, c.seriesTerms), n.Position}
+	}
+	return ends[0], ends[1], nil
+}
+
+// Adds up or multiplies out the terms of a sum or product, one with no terms is 0 or 1
+func (c *Calculator) evalSeries(n *SeriesNode, vars map[string]float64) (float64, error) {
+	from, to, err := c.seriesRange(n, func(node Node) (float64, error) { return c.eval(node, vars) })
+	if err != nil {
+		return 0, err
+	}
+	result := 0.0
+	if n.Op == "prod" {
+		result = 1
+	}
+	scoped := withVariable(vars, n.Index, 0)
+	for k := from; k <= to; k++ {
+		scoped[n.Index] = float64(k)
+		term, err := c.eval(n.Body, scoped)
+		if err != nil {
+			return 0, err
+		}
+		if n.Op == "prod" {
+			result *= term
+		} else {
+			result += term
+		}
+	}
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return 0, &ExpressionError{fmt.Sprintf("%s produced an invalid result", n.Op), n.Position}
+	}
+	return result, nil
+}
+
+// evalSeries over complex numbers, the bounds still have to come out as whole numbers
+func (c *Calculator) evalComplexSeries(n *SeriesNode, vars map[string]complex128) (complex128, error) {
+	from, to, err := c.seriesRange(n, func(node Node) (float64, error) {
+		z, err := c.evalComplex(node, vars)
+		if err == nil && cleanComplex(z) != complex(real(z), 0) {
+			err = &ExpressionError{fmt.Sprintf("%s bounds must be whole numbers, got %s", n.Op, formatComplex(z)), node.Pos()}
+		}
+		return real(z), err
+	})
+	if err != nil {
+		return 0, err
+	}
+	var result complex128
+	if n.Op == "prod" {
+		result = 1
+	}
+	scoped := make(map[string]complex128, len(vars)+1)
+	for name, value := range vars {
+		scoped[name] = value
+	}
+	for k := from; k <= to; k++ {
+		scoped[n.Index] = complex(float64(k), 0)
+		term, err := c.evalComplex(n.Body, scoped)
+		if err != nil {
+			return 0, err
+		}
+		if n.Op == "prod" {
+			result *= term
+		} else {
+			result += term
+		}
+	}
+	return result, nil
+}
+
+// evalSeries with exact fractions, so sum(k, 1, 10, 1/k) comes out as 7381/2520
+func (c *Calculator) evalPreciseSeries(n *SeriesNode, vars map[string]preciseValue, prec uint) (preciseValue, error) {
+	from, to, err := c.seriesRange(n, func(node Node) (float64, error) {
+		value, err := c.evalPrecise(node, vars, prec)
+		return value.float64(), err
+	})
+	if err != nil {
+		return preciseValue{}, err
+	}
+	step := &BinaryNode{Op: "+", Position: n.Position}
+	result := exact(new(big.Rat))
+	if n.Op == "prod" {
+		step.Op, result = "*", exact(big.NewRat(1, 1))
+	}
+	scoped := make(map[string]preciseValue, len(vars)+1)
+	for name, value := range vars {
+		scoped[name] = value
+	}
+	for k := from; k <= to; k++ {
+		scoped[n.Index] = exact(new(big.Rat).SetInt64(k))
+		term, err := c.evalPrecise(n.Body, scoped, prec)
+		if err != nil {
+			return preciseValue{}, err
+		}
+		if result, err = c.preciseBinary(step, result, term, prec); err != nil {
+			return preciseValue{}, err
+		}
+	}
+	return result, nil
+}
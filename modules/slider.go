@@ -0,0 +1,151 @@
+package modules
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+var sliderTrackColor = tcell.NewRGBColor(90, 90, 100)
+
+// slider is drawn in place of a constant row's response field, dragging it, scrolling over it or using
+// the arrow keys while it has focus calls changed with the new value, snapped to the step
+type slider struct {
+	*tview.Box
+	min, max, step float64
+	value          float64
+	color          tcell.Color
+	changed        func(value float64)
+}
+
+func newSlider(color tcell.Color, changed func(value float64)) *slider {
+	return &slider{
+		Box:     tview.NewBox().SetBackgroundColor(tcell.ColorBlack),
+		min:     -10,
+		max:     10,
+		step:    0.1,
+		color:   color,
+		changed: changed,
+	}
+}
+
+func (s *slider) setRange(min, max, step float64) {
+	s.min, s.max, s.step = min, max, step
+}
+
+// Formats a value with as many decimals as the step has
+func (s *slider) format(value float64) string {
+	decimals := 0
+	if text := strconv.FormatFloat(s.step, 'f', -1, 64); strings.Contains(text, ".") {
+		decimals = len(text) - strings.Index(text, ".") - 1
+	}
+	return strconv.FormatFloat(value, 'f', decimals, 64)
+}
+
+// Snaps value onto the step grid within the range and reports it if it moved
+func (s *slider) set(value float64) {
+	value = s.min + math.Round((value-s.min)/s.step)*s.step
+	value = math.Max(s.min, math.Min(s.max, value))
+	if value != s.value && s.changed != nil {
+		s.value = value
+		s.changed(value)
+	}
+}
+
+// Layout of one slider line: "min ━━━━●──── max  = value"
+func (s *slider) track() (left string, start, width int, right string) {
+	x, _, w, _ := s.GetInnerRect()
+	left = fmt.Sprintf(" %g ", s.min)
+	right = fmt.Sprintf(" %g  = %s", s.max, s.format(s.value))
+	width = w - len(left) - len(right)
+	return left, x + len(left), width, right
+}
+
+func (s *slider) Draw(screen tcell.Screen) {
+	s.Box.DrawForSubclass(screen, s)
+	x, y, w, _ := s.GetInnerRect()
+	left, start, width, right := s.track()
+	if width < 3 {
+		tview.Print(screen, " = "+s.format(s.value), x, y, w, tview.AlignRight, tcell.ColorWhite)
+		return
+	}
+
+	tview.Print(screen, left, x, y, len(left), tview.AlignLeft, tcell.ColorWhite)
+	knob := int(math.Round((s.value - s.min) / (s.max - s.min) * float64(width-1)))
+	for i := 0; i < width; i++ {
+		style := tcell.StyleDefault.Background(tcell.ColorBlack)
+		switch {
+		case i == knob && s.HasFocus():
+			screen.SetContent(start+i, y, '◉', nil, style.Foreground(tcell.ColorWhite))
+		case i == knob:
+			screen.SetContent(start+i, y, '●', nil, style.Foreground(s.color))
+		case i < knob:
+			screen.SetContent(start+i, y, '━', nil, style.Foreground(s.color))
+		default:
+			screen.SetContent(start+i, y, '─', nil, style.Foreground(sliderTrackColor))
+		}
+	}
+	tview.Print(screen, right, start+width, y, len(right), tview.AlignLeft, tcell.ColorWhite)
+}
+
+// Left/Right move one step, Home/End jump to the ends of the range
+func (s *slider) InputHandler() func(event *tcell.EventKey, setFocus func(p tview.Primitive)) {
+	return s.WrapInputHandler(func(event *tcell.EventKey, setFocus func(p tview.Primitive)) {
+		switch event.Key() {
+		case tcell.KeyLeft, tcell.KeyDown:
+			s.set(s.value - s.step)
+		case tcell.KeyRight, tcell.KeyUp:
+			s.set(s.value + s.step)
+		case tcell.KeyHome:
+			s.set(s.min)
+		case tcell.KeyEnd:
+			s.set(s.max)
+		}
+	})
+}
+
+// Clicking or dragging along the track moves the knob there, scrolling moves it one step
+func (s *slider) MouseHandler() func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+	return s.WrapMouseHandler(func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+		mx, my := event.Position()
+		_, start, width, _ := s.track()
+		setFromMouse := func() {
+			if width > 1 {
+				offset := math.Max(0, math.Min(float64(width-1), float64(mx-start)))
+				s.set(s.min + offset/float64(width-1)*(s.max-s.min))
+			}
+		}
+
+		switch action {
+		case tview.MouseLeftDown:
+			if !s.InRect(mx, my) {
+				return false, nil
+			}
+			setFocus(s)
+			setFromMouse()
+			return true, s // capture the mouse so the drag keeps reaching the slider
+		case tview.MouseMove:
+			if event.Buttons()&tcell.Button1 != 0 && s.HasFocus() {
+				setFromMouse()
+				return true, s
+			}
+		case tview.MouseLeftUp:
+			return s.HasFocus(), nil
+		case tview.MouseScrollUp, tview.MouseScrollDown:
+			if !s.InRect(mx, my) {
+				return false, nil
+			}
+			if action == tview.MouseScrollUp {
+				s.set(s.value + s.step)
+			} else {
+				s.set(s.value - s.step)
+			}
+			return true, nil
+		}
+		return false, nil
+	})
+}
@@ -0,0 +1,207 @@
+package modules
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The (x, y) points of a table row, typed in or read from a CSV file
+type dataTable struct {
+	xs, ys []float64
+}
+
+// Summaries of a table column, written mean(t1.y), each is parsed into a StatNode
+var statistics = map[string]func(values []float64) (float64, error){
+	"count": func(values []float64) (float64, error) { return float64(len(values)), nil },
+	"total": func(values []float64) (float64, error) { return total(values), nil },
+	"mean":  mean,
+	"median": func(values []float64) (float64, error) {
+		if len(values) == 0 {
+			return 0, errors.New("the median of no values is undefined")
+		}
+		sorted := slices.Sorted(slices.Values(values))
+		middle := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return (sorted[middle-1] + sorted[middle]) / 2, nil
+		}
+		return sorted[middle], nil
+	},
+	"variance": variance,
+	"stdev": func(values []float64) (float64, error) {
+		v, err := variance(values)
+		return math.Sqrt(v), err
+	},
+}
+
+func total(values []float64) float64 {
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	return sum
+}
+
+func mean(values []float64) (float64, error) {
+	if len(values) == 0 {
+		return 0, errors.New("the mean of no values is undefined")
+	}
+	return total(values) / float64(len(values)), nil
+}
+
+// Variance of a sample, dividing by one less than the number of values, stdev is its square root
+func variance(values []float64) (float64, error) {
+	if len(values) < 2 {
+		return 0, errors.New("the variance needs at least 2 values")
+	}
+	m, _ := mean(values)
+	squares := 0.0
+	for _, value := range values {
+		squares += (value - m) * (value - m)
+	}
+	return squares / float64(len(values)-1), nil
+}
+
+// SetTable registers (or replaces) a table of points under name, its columns are then name.x and name.y
+func (c *Calculator) SetTable(name string, xs, ys []float64) error {
+	if len(xs) != len(ys) {
+		return fmt.Errorf("a table needs as many x values as y values, got %d and %d", len(xs), len(ys))
+	}
+	c.tables[name] = dataTable{slices.Clone(xs), slices.Clone(ys)}
+	return nil
+}
+
+// The values of a column of a table, column is "x" or "y"
+func (c *Calculator) column(table, column string) ([]float64, bool) {
+	t, exists := c.tables[table]
+	if !exists {
+		return nil, false
+	}
+	if column == "x" {
+		return t.xs, true
+	}
+	return t.ys, true
+}
+
+// Length of the column name, x or y, that text starts with after a table name, 0 if there is none
+func columnSuffix(text string) int {
+	if len(text) < 2 || text[0] != '.' || (text[1] != 'x' && text[1] != 'y') {
+		return 0
+	}
+	if len(text) > 2 && ((text[2] >= 'a' && text[2] <= 'z') || (text[2] >= '0' && text[2] <= '9')) {
+		return 0
+	}
+	return 2
+}
+
+// Reports whether a row is a table, table((1, 2), (2, 4)) or csv(data.csv)
+func isTable(text string) bool {
+	text = strings.TrimSpace(text)
+	return strings.HasPrefix(text, "table(") || strings.HasPrefix(text, "csv(")
+}
+
+// Reads the points of a table row, offset is where text starts in the row
+func (c *Calculator) parseTable(text string, offset int) (xs, ys []float64, source string, err error) {
+	trimmed := strings.TrimSpace(text)
+	offset += strings.Index(text, trimmed)
+	open := strings.Index(trimmed, "(")
+	if !strings.HasSuffix(trimmed, ")") {
+		return nil, nil, "", &ExpressionError{"missing closing parenthesis", offset + len(trimmed)}
+	}
+	inner := trimmed[open+1 : len(trimmed)-1]
+	if trimmed[:open] == "csv" {
+		path := strings.Trim(strings.TrimSpace(inner), `"'`)
+		xs, ys, err = cachedCSV(path)
+		if err != nil {
+			return nil, nil, "", &ExpressionError{err.Error(), offset + open + 1}
+		}
+		return xs, ys, path, nil
+	}
+	for _, part := range splitTopLevel(inner) {
+		at := offset + open + 1 + part.offset
+		node, err := c.parseWith(part.text, nil)
+		if err != nil {
+			return nil, nil, "", offsetError(err, at)
+		}
+		point, ok := node.(*TupleNode)
+		if !ok || len(point.Items) != 2 {
+			return nil, nil, "", &ExpressionError{"each point of a table is written (x, y)", at + node.Pos()}
+		}
+		var values [2]float64
+		for i, item := range point.Items {
+			if values[i], err = c.evaluate(item, nil); err != nil {
+				return nil, nil, "", offsetError(err, at)
+			}
+		}
+		xs, ys = append(xs, values[0]), append(ys, values[1])
+	}
+	return xs, ys, "", nil
+}
+
+// A CSV file as it was last read, kept so rows referring to it don't read it again on every keystroke
+type csvFile struct {
+	modified time.Time
+	size     int64
+	xs, ys   []float64
+}
+
+var csvFiles = map[string]csvFile{}
+
+// ReadCSV, reading the file again only when its modification time or size has changed since it was last read
+func cachedCSV(path string) (xs, ys []float64, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		delete(csvFiles, path)
+		return nil, nil, fmt.Errorf("cannot open %s", path)
+	}
+	if cached, ok := csvFiles[path]; ok && cached.modified.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.xs, cached.ys, nil
+	}
+	if xs, ys, err = ReadCSV(path); err != nil {
+		delete(csvFiles, path)
+		return nil, nil, err
+	}
+	csvFiles[path] = csvFile{info.ModTime(), info.Size(), xs, ys}
+	return xs, ys, nil
+}
+
+// ReadCSV reads points from the first two columns of a CSV file, a first line that isn't
+// numbers is taken to be a header and skipped
+func ReadCSV(path string) (xs, ys []float64, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot open %s", path)
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1 // rows may carry more columns than the two that are used
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read %s: %v", path, err)
+	}
+	for line, record := range records {
+		if len(record) < 2 {
+			return nil, nil, fmt.Errorf("line %d of %s needs an x and a y value", line+1, path)
+		}
+		x, xErr := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
+		y, yErr := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if xErr != nil || yErr != nil {
+			if line == 0 {
+				continue
+			}
+			return nil, nil, fmt.Errorf("line %d of %s is not a pair of numbers", line+1, path)
+		}
+		xs, ys = append(xs, x), append(ys, y)
+	}
+	if len(xs) == 0 {
+		return nil, nil, fmt.Errorf("%s has no points", path)
+	}
+	return xs, ys, nil
+}
@@ -0,0 +1,176 @@
+package modules
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Which grid lines are drawn behind the graph
+type gridLines int
+
+const (
+	gridOff gridLines = iota
+	gridMajor
+	gridMinor // the major lines with fainter ones between them
+)
+
+var gridNames = []string{"Off", "Major", "Minor"}
+
+var showGrid = gridOff
+
+var (
+	gridColor      = color.RGBA{190, 190, 190, 255}
+	minorGridColor = color.RGBA{225, 225, 225, 255}
+)
+
+const (
+	labelColumns = 10    // terminal columns given to each label along the x axis
+	labelRows    = 3     // terminal rows between labels along the y axis
+	minorCells   = 2     // terminal cells minor grid lines are kept apart by, along either axis
+	maxMultiples = 10000 // most tick values or grid lines worked out along an axis, however the view was set
+)
+
+// A "nice" step of 1, 2 or 5 times a power of ten that divides span into about count pieces
+func niceStep(span float64, count int) float64 {
+	raw := span / float64(max(count, 1))
+	power := math.Pow(10, math.Floor(math.Log10(raw)))
+	switch fraction := raw / power; {
+	case fraction < 1.5:
+		return power
+	case fraction < 3.5:
+		return 2 * power
+	case fraction < 7.5:
+		return 5 * power
+	}
+	return 10 * power
+}
+
+// Size of the graph in terminal cells
+func graphCells() (width, height int) {
+	_, _, width, height = Graph.GetInnerRect()
+	if width <= 0 || height <= 0 {
+		return 80, 24 // not laid out yet
+	}
+	return width, height
+}
+
+// Steps between the major ticks of each axis, as many as there is room to label at the graph's size
+func tickSteps() (xStep, yStep float64) {
+	width, height := graphCells()
+	return niceStep(viewXMax-viewXMin, width/labelColumns), niceStep(viewYMax-viewYMin, height/labelRows)
+}
+
+// Minor grid lines split a step of 2 in quarters and steps of 1 and 5 in fifths, or only in halves where
+// that would crowd them closer than minorCells, and there are none where even halves would
+func minorStep(step, span float64, cells int) float64 {
+	parts := 5.0
+	if fraction := step / math.Pow(10, math.Floor(math.Log10(step))); math.Round(fraction) == 2 {
+		parts = 4
+	}
+	for _, parts := range []float64{parts, 2} {
+		if float64(cells)*step/parts/span >= minorCells {
+			return step / parts
+		}
+	}
+	return 0
+}
+
+// The multiples of step inside [from, to], counted from a whole number of steps so they don't drift,
+// and never more than maxMultiples of them
+func multiples(from, to, step float64) []float64 {
+	var values []float64
+	first := math.Ceil(from / step)
+	for i := 0; i < maxMultiples; i++ {
+		value := (first + float64(i)) * step
+		if value > to || math.IsNaN(value) {
+			break
+		}
+		if math.Abs(value) < step*1e-9 {
+			value = 0 // not -0 or 1e-17 from rounding
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// Draws the grid lines the Grid control asks for, beneath everything else on the graph
+func drawGrid(img *image.RGBA, xStep, yStep float64) {
+	if showGrid == gridOff {
+		return
+	}
+	lines := func(step, from, to float64, line func(float64)) {
+		if step > 0 {
+			for _, value := range multiples(from, to, step) {
+				line(value)
+			}
+		}
+	}
+	vertical := func(c color.Color) func(float64) {
+		return func(x float64) {
+			px, _ := toImage(x, 0)
+			drawLine(img, px, 0, px, lastImageHeight-1, stroke.grid, c)
+		}
+	}
+	horizontal := func(c color.Color) func(float64) {
+		return func(y float64) {
+			_, py := toImage(0, y)
+			drawLine(img, 0, py, lastImageWidth-1, py, stroke.grid, c)
+		}
+	}
+	if showGrid == gridMinor {
+		width, height := graphCells()
+		lines(minorStep(xStep, viewXMax-viewXMin, width), viewXMin, viewXMax, vertical(minorGridColor))
+		lines(minorStep(yStep, viewYMax-viewYMin, height), viewYMin, viewYMax, horizontal(minorGridColor))
+	}
+	lines(xStep, viewXMin, viewXMax, vertical(gridColor))
+	lines(yStep, viewYMin, viewYMax, horizontal(gridColor))
+}
+
+// A tick value written with as many decimals as the step needs, in e notation once it gets very large or small
+func formatTick(value, step float64) string {
+	if value != 0 && (math.Abs(value) >= 1e6 || step < 1e-4) {
+		return strconv.FormatFloat(value, 'g', 4, 64)
+	}
+	decimals := max(0, int(-math.Floor(math.Log10(step)+1e-9)))
+	return strconv.FormatFloat(value, 'f', decimals, 64)
+}
+
+// Writes the values of the major ticks as text over the graph, the x values along its bottom row
+// and the y values down its left side, skipping any that would run into the one before
+func drawLabels(screen tcell.Screen, left, top, width, height int) {
+	if width <= 0 || height <= 0 {
+		return
+	}
+	style := tcell.StyleDefault.Foreground(tcell.ColorBlack).Background(tcell.ColorWhite)
+	write := func(col, row int, text string) {
+		for i, char := range text {
+			screen.SetContent(col+i, row, char, nil, style)
+		}
+	}
+	xStep, yStep := tickSteps()
+	bottom := top + height - 1
+	next := left // first column free for a label
+	for _, x := range multiples(viewXMin, viewXMax, xStep) {
+		text := formatTick(x, xStep)
+		col := left + int(mapRange(x, viewXMin, viewXMax, 0, float64(width-1))+0.5) - len(text)/2
+		col = max(left, min(col, left+width-len(text)))
+		if col < next {
+			continue
+		}
+		write(col, bottom, text)
+		next = col + len(text) + 1
+	}
+	last := -1 // row of the label before
+	for _, y := range multiples(viewYMin, viewYMax, yStep) {
+		row := top + int(mapRange(y, viewYMin, viewYMax, float64(height-1), 0)+0.5)
+		if row >= bottom || row == last {
+			continue // the x labels have the bottom row
+		}
+		write(left, row, formatTick(y, yStep))
+		last = row
+	}
+}
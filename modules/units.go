@@ -0,0 +1,474 @@
+package modules
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// dimension holds the exponents of the SI base units a quantity is measured in, in the order of baseUnits
+type dimension [7]int
+
+var baseUnits = [7]string{"kg", "m", "s", "A", "K", "mol", "cd"}
+
+var (
+	mass        = dimension{1}
+	length      = dimension{0, 1}
+	duration    = dimension{0, 0, 1}
+	current     = dimension{0, 0, 0, 1}
+	temperature = dimension{0, 0, 0, 0, 1}
+	amount      = dimension{0, 0, 0, 0, 0, 1}
+	luminosity  = dimension{0, 0, 0, 0, 0, 0, 1}
+	area        = dimension{0, 2}
+	volume      = dimension{0, 3}
+	speed       = dimension{0, 1, -1}
+	frequency   = dimension{0, 0, -1}
+	force       = dimension{1, 1, -2}
+	energy      = dimension{1, 2, -2}
+	power       = dimension{1, 2, -3}
+	pressure    = dimension{1, -1, -2}
+	charge      = dimension{0, 0, 1, 1}
+	voltage     = dimension{1, 2, -3, -1}
+	resistance  = dimension{1, 2, -3, -2}
+	angle       = dimension{} // radians are a ratio of lengths
+)
+
+// A unit of measurement, factor is its size in SI base units, exact the same as a fraction
+type unit struct {
+	factor float64
+	exact  *big.Rat
+	dim    dimension
+}
+
+func newUnit(factor string, dim dimension) unit {
+	exact, _ := new(big.Rat).SetString(factor)
+	value, _ := exact.Float64()
+	return unit{value, exact, dim}
+}
+
+// Units numbers can be written with, 9.81 m/s^2. Names already taken by a constant, function or
+// parameter keep their meaning, so these only apply where a name would otherwise be unknown.
+var units = map[string]unit{
+	// length
+	"m":  newUnit("1", length),
+	"km": newUnit("1000", length),
+	"cm": newUnit("0.01", length),
+	"mm": newUnit("0.001", length),
+	"um": newUnit("0.000001", length),
+	"nm": newUnit("0.000000001", length),
+	"in": newUnit("0.0254", length),
+	"ft": newUnit("0.3048", length),
+	"yd": newUnit("0.9144", length),
+	"mi": newUnit("1609.344", length),
+	// mass
+	"kg": newUnit("1", mass),
+	"g":  newUnit("0.001", mass),
+	"mg": newUnit("0.000001", mass),
+	"lb": newUnit("0.45359237", mass),
+	"oz": newUnit("0.028349523125", mass),
+	// time
+	"s":   newUnit("1", duration),
+	"ms":  newUnit("0.001", duration),
+	"min": newUnit("60", duration),
+	"h":   newUnit("3600", duration),
+	"day": newUnit("86400", duration),
+	// the other base units
+	"A":   newUnit("1", current),
+	"mA":  newUnit("0.001", current),
+	"K":   newUnit("1", temperature),
+	"mol": newUnit("1", amount),
+	"cd":  newUnit("1", luminosity),
+	// derived units
+	"L":    newUnit("0.001", volume),
+	"mL":   newUnit("0.000001", volume),
+	"ha":   newUnit("10000", area),
+	"mph":  newUnit("0.44704", speed),
+	"Hz":   newUnit("1", frequency),
+	"rpm":  newUnit("0.0166666666666666666666666666666666666666666666666666666666666667", frequency),
+	"N":    newUnit("1", force),
+	"kN":   newUnit("1000", force),
+	"lbf":  newUnit("4.4482216152605", force),
+	"J":    newUnit("1", energy),
+	"kJ":   newUnit("1000", energy),
+	"cal":  newUnit("4.184", energy),
+	"kcal": newUnit("4184", energy),
+	"Wh":   newUnit("3600", energy),
+	"kWh":  newUnit("3600000", energy),
+	"W":    newUnit("1", power),
+	"kW":   newUnit("1000", power),
+	"hp":   newUnit("745.69987158227022", power),
+	"Pa":   newUnit("1", pressure),
+	"kPa":  newUnit("1000", pressure),
+	"MPa":  newUnit("1000000", pressure),
+	"GPa":  newUnit("1000000000", pressure),
+	"bar":  newUnit("100000", pressure),
+	"psi":  newUnit("6894.757293168361", pressure),
+	"C":    newUnit("1", charge),
+	"V":    newUnit("1", voltage),
+	"ohm":  newUnit("1", resistance),
+	"rad":  newUnit("1", angle),
+	"deg":  newUnit("0.0174532925199432957692369076848861271344287188854172545609719144", angle),
+}
+
+// Units results are written in when their dimension matches one, before falling back to base units
+var namedUnits = []string{"N", "J", "W", "Pa", "C", "V", "ohm"}
+
+// Built in functions whose arguments share one unit and whose result is in it too, every other
+// built in takes plain numbers
+var sameUnitFuncs = map[string]bool{
+	"abs": true, "floor": true, "ceil": true, "round": true, "mod": true, "min": true, "max": true,
+	"clamp": true, "hypot": true, "re": true, "im": true, "conj": true,
+}
+
+func (d dimension) none() bool {
+	return d == dimension{}
+}
+
+func (d dimension) times(other dimension) dimension {
+	for i := range d {
+		d[i] += other[i]
+	}
+	return d
+}
+
+func (d dimension) over(other dimension) dimension {
+	for i := range d {
+		d[i] -= other[i]
+	}
+	return d
+}
+
+// Raises d to exponent, which only works out when every base unit ends up with a whole power
+func (d dimension) pow(exponent float64) (dimension, bool) {
+	for i := range d {
+		p := float64(d[i]) * exponent
+		if p != math.Trunc(p) || math.Abs(p) > 1000 {
+			return d, false
+		}
+		d[i] = int(p)
+	}
+	return d, true
+}
+
+// Writes the dimension as a named unit (N, J, ...) or in base units: kg m/s^2, s^-1
+func (d dimension) String() string {
+	if d.none() {
+		return "a plain number"
+	}
+	for _, name := range namedUnits {
+		if units[name].dim == d {
+			return name
+		}
+	}
+	var num, den []string
+	for i, exponent := range d {
+		text := baseUnits[i]
+		if exponent > 1 || exponent < -1 {
+			text += "^" + strconv.Itoa(max(exponent, -exponent))
+		}
+		switch {
+		case exponent > 0:
+			num = append(num, text)
+		case exponent < 0:
+			den = append(den, text)
+		}
+	}
+	text := strings.Join(num, " ")
+	if text == "" {
+		// Nothing above the line, 1/s reads badly after a number so powers go negative instead: s^-1
+		for i, part := range den {
+			if !strings.Contains(part, "^") {
+				part += "^1"
+			}
+			den[i] = strings.Replace(part, "^", "^-", 1)
+		}
+		return strings.Join(den, " ")
+	}
+	switch {
+	case len(den) == 1:
+		text += "/" + den[0]
+	case len(den) > 1:
+		text += "/(" + strings.Join(den, " ") + ")"
+	}
+	return text
+}
+
+// Appends the unit to a formatted value, "29.43 m/s"
+func withUnit(value string, d dimension) string {
+	if d.none() {
+		return value
+	}
+	if strings.Contains(value, " ") {
+		value = "(" + value + ")" // complex values: (1 + 2i) m
+	}
+	return value + " " + d.String()
+}
+
+// QuantityString evaluates a constant expression with units and writes the result in SI units,
+// "9.81 m/s^2 * 3 s" gives "29.43 m/s"
+func (c *Calculator) QuantityString(expr string) (string, error) {
+	node, err := c.Parse(expr)
+	if err != nil {
+		return "", err
+	}
+	d, err := c.dimensionOf(node, nil)
+	if err != nil {
+		return "", err
+	}
+	value, err := c.evaluate(node, map[string]float64{"x": 0})
+	if err != nil {
+		return "", err
+	}
+	return withUnit(strconv.FormatFloat(value, 'g', -1, 64), d), nil
+}
+
+// Records the units of a user constant, whose value is stored in SI base units
+func (c *Calculator) setConstantUnits(name string, d dimension) {
+	if d.none() {
+		delete(c.constantUnits, name)
+		return
+	}
+	c.constantUnits[name] = d
+}
+
+// dimensionOf works out the units of an expression without evaluating it, reporting where units don't
+// agree: 3 m + 2 s. Variables are plain numbers unless vars gives them units.
+func (c *Calculator) dimensionOf(node Node, vars map[string]dimension) (dimension, error) {
+	switch n := node.(type) {
+	case *NumberNode:
+		return dimension{}, nil
+
+	case *VariableNode:
+		return vars[n.Name], nil
+
+	case *ConstantNode:
+		return c.constantUnits[n.Name], nil
+
+	case *UnitNode:
+		return units[n.Name].dim, nil
+
+	case *UnaryNode:
+		return c.dimensionOf(n.Operand, vars)
+
+	case *BinaryNode:
+		a, err := c.dimensionOf(n.Left, vars)
+		if err != nil {
+			return dimension{}, err
+		}
+		b, err := c.dimensionOf(n.Right, vars)
+		if err != nil {
+			return dimension{}, err
+		}
+		switch n.Op {
+		case "+":
+			if a != b {
+				return dimension{}, &ExpressionError{fmt.Sprintf("cannot add %s and %s", a, b), n.Position}
+			}
+			return a, nil
+		case "-":
+			if a != b {
+				return dimension{}, &ExpressionError{fmt.Sprintf("cannot subtract %s from %s", b, a), n.Position}
+			}
+			return a, nil
+		case "*":
+			return a.times(b), nil
+		case "/":
+			return a.over(b), nil
+		case "^":
+			if !b.none() {
+				return dimension{}, &ExpressionError{fmt.Sprintf("an exponent cannot have units, got %s", b), n.Right.Pos()}
+			}
+			if a.none() {
+				return a, nil
+			}
+			exponent, err := c.eval(n.Right, nil)
+			if err != nil {
+				return dimension{}, &ExpressionError{fmt.Sprintf("a power of %s needs a constant exponent", a), n.Position}
+			}
+			result, ok := a.pow(exponent)
+			if !ok {
+				return dimension{}, &ExpressionError{fmt.Sprintf("(%s)^%g does not come out in whole units", a, exponent), n.Position}
+			}
+			return result, nil
+		}
+		return dimension{}, &ExpressionError{fmt.Sprintf("unknown operator: %s", n.Op), n.Position}
+
+	case *CallNode:
+		return c.callDimension(n, vars)
+
+	case *DerivativeNode:
+		if n.Result != nil {
+			return c.dimensionOf(n.Result, vars)
+		}
+		d, err := c.dimensionOf(n.Operand, vars)
+		return d.over(vars["x"]), err
+
+	case *IntegralNode:
+		lower, upper, err := c.sameDimension(n.Lower, n.Upper, vars)
+		if err != nil {
+			return dimension{}, err
+		}
+		if lower != upper {
+			return dimension{}, &ExpressionError{fmt.Sprintf("both bounds of an integral need the same units, got %s and %s", lower, upper), n.Upper.Pos()}
+		}
+		scoped := make(map[string]dimension, len(vars)+1)
+		for name, d := range vars {
+			scoped[name] = d
+		}
+		scoped[n.Variable] = lower
+		body, err := c.dimensionOf(n.Body, scoped)
+		return body.times(lower), err
+
+	case *SeriesNode:
+		for _, bound := range []Node{n.From, n.To} {
+			d, err := c.dimensionOf(bound, vars)
+			if err != nil {
+				return dimension{}, err
+			}
+			if !d.none() {
+				return dimension{}, &ExpressionError{fmt.Sprintf("%s bounds cannot have units, got %s", n.Op, d), bound.Pos()}
+			}
+		}
+		scoped := make(map[string]dimension, len(vars)+1)
+		for name, d := range vars {
+			scoped[name] = d
+		}
+		scoped[n.Index] = dimension{}
+		body, err := c.dimensionOf(n.Body, scoped)
+		if err == nil && n.Op == "prod" && !body.none() {
+			err = &ExpressionError{fmt.Sprintf("the terms of a product cannot have units, got %s", body), n.Body.Pos()}
+		}
+		return body, err
+
+	case *TupleNode:
+		for _, item := range n.Items {
+			if _, err := c.dimensionOf(item, vars); err != nil {
+				return dimension{}, err
+			}
+		}
+		return dimension{}, nil
+
+	case *EquationNode:
+		a, b, err := c.sameDimension(n.Left, n.Right, vars)
+		if err == nil && a != b {
+			err = &ExpressionError{fmt.Sprintf("both sides of an equation need the same units, got %s and %s", a, b), n.Position}
+		}
+		return dimension{}, err
+
+	case *ComparisonNode:
+		a, b, err := c.sameDimension(n.Left, n.Right, vars)
+		if err == nil && a != b {
+			err = &ExpressionError{fmt.Sprintf("cannot compare %s with %s", a, b), n.Position}
+		}
+		return dimension{}, err
+
+	case *LogicalNode:
+		_, _, err := c.sameDimension(n.Left, n.Right, vars)
+		return dimension{}, err
+
+	case *PiecewiseNode:
+		values := n.Values
+		if n.Otherwise != nil {
+			values = append(values[:len(values):len(values)], n.Otherwise)
+		}
+		for _, condition := range n.Conditions {
+			if _, err := c.dimensionOf(condition, vars); err != nil {
+				return dimension{}, err
+			}
+		}
+		var result dimension
+		for i, value := range values {
+			d, err := c.dimensionOf(value, vars)
+			if err != nil {
+				return dimension{}, err
+			}
+			if i > 0 && d != result {
+				return dimension{}, &ExpressionError{fmt.Sprintf("every piece needs the same units, got %s and %s", result, d), value.Pos()}
+			}
+			result = d
+		}
+		return result, nil
+	}
+	return dimension{}, nil
+}
+
+// Works out the units of both sides of a binary node, leaving the comparison to the caller
+func (c *Calculator) sameDimension(left, right Node, vars map[string]dimension) (dimension, dimension, error) {
+	a, err := c.dimensionOf(left, vars)
+	if err != nil {
+		return dimension{}, dimension{}, err
+	}
+	b, err := c.dimensionOf(right, vars)
+	return a, b, err
+}
+
+func (c *Calculator) callDimension(n *CallNode, vars map[string]dimension) (dimension, error) {
+	args := make([]dimension, len(n.Args))
+	for i, arg := range n.Args {
+		d, err := c.dimensionOf(arg, vars)
+		if err != nil {
+			return dimension{}, err
+		}
+		args[i] = d
+	}
+
+	// User functions are in whatever units their body comes out in for these arguments
+	if f, exists := c.lookupFunction(n.Name); exists {
+		if f.body != nil {
+			scoped := make(map[string]dimension, len(f.params))
+			for i, param := range f.params {
+				if i < len(args) {
+					scoped[param] = args[i]
+				}
+			}
+			d, err := c.dimensionOf(f.body, scoped)
+			if err != nil {
+				return dimension{}, &ExpressionError{fmt.Sprintf("in %s: %s", n.Name, unitMessage(err)), n.Position}
+			}
+			return d, nil
+		}
+		return dimension{}, c.plainArguments(n, args)
+	}
+
+	switch {
+	case n.Name == "sqrt":
+		d, ok := args[0].pow(0.5)
+		if !ok {
+			return dimension{}, &ExpressionError{fmt.Sprintf("the square root of %s does not come out in whole units", args[0]), n.Position}
+		}
+		return d, nil
+	case n.Name == "atan2":
+		if args[0] != args[1] {
+			return dimension{}, &ExpressionError{fmt.Sprintf("atan2 needs both arguments in the same units, got %s and %s", args[0], args[1]), n.Args[1].Pos()}
+		}
+		return dimension{}, nil
+	case sameUnitFuncs[n.Name]:
+		for i := 1; i < len(args); i++ {
+			if args[i] != args[0] {
+				return dimension{}, &ExpressionError{fmt.Sprintf("%s needs all arguments in the same units, got %s and %s", n.Name, args[0], args[i]), n.Args[i].Pos()}
+			}
+		}
+		return args[0], nil
+	}
+	return dimension{}, c.plainArguments(n, args)
+}
+
+// Reports the first argument with units to a function that only takes plain numbers
+func (c *Calculator) plainArguments(n *CallNode, args []dimension) error {
+	for i, d := range args {
+		if !d.none() {
+			return &ExpressionError{fmt.Sprintf("%s needs an argument without units, got %s", n.Name, d), n.Args[i].Pos()}
+		}
+	}
+	return nil
+}
+
+// The message of err without its position, which points into another expression
+func unitMessage(err error) string {
+	if exprErr, ok := err.(*ExpressionError); ok {
+		return exprErr.Message
+	}
+	return err.Error()
+}